@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// ways of flattening labels into Graphite paths
+const (
+	// labelsAsPath appends label names and values: name.label.value
+	labelsAsPath = "path"
+	// labelsAsValues appends the label values only: name.value
+	labelsAsValues = "values"
+	// labelsAsTags uses Graphite tags: name;label=value
+	labelsAsTags = "tags"
+)
+
+// bridgeTimeout bounds connecting and writing to a bridge endpoint
+const bridgeTimeout = 10 * time.Second
+
+// bridge sends the samples of a gatherer to Graphite or StatsD, flattening
+// their labels into dotted paths
+type bridge struct {
+	name     string
+	gatherer prometheus.Gatherer
+	prefix   string
+	labels   string
+	// send writes the lines of one interval
+	send     func(lines []string) error
+	failures prometheus.Counter
+}
+
+// newGraphiteBridge sends to the Carbon plaintext protocol on a TCP address
+func newGraphiteBridge(address, prefix, labels string, registry *prometheus.Registry) (*bridge, error) {
+	b, err := newBridge("graphite", prefix, labels, registry)
+	if err != nil {
+		return nil, err
+	}
+	b.send = func(lines []string) error {
+		conn, err := net.DialTimeout("tcp", address, bridgeTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetWriteDeadline(time.Now().Add(bridgeTimeout))
+		w := bufio.NewWriter(conn)
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		for _, line := range lines {
+			fmt.Fprintf(w, "%s %s\n", line, now)
+		}
+		return w.Flush()
+	}
+	return b, nil
+}
+
+// maxStatsdPacket keeps StatsD datagrams below common MTUs
+const maxStatsdPacket = 1432
+
+// newStatsdBridge sends the samples as StatsD gauges to a UDP address
+func newStatsdBridge(address, prefix, labels string, registry *prometheus.Registry) (*bridge, error) {
+	if labels == labelsAsTags {
+		return nil, fmt.Errorf("StatsD does not support %s labels", labelsAsTags)
+	}
+	b, err := newBridge("statsd", prefix, labels, registry)
+	if err != nil {
+		return nil, err
+	}
+	b.send = func(lines []string) error {
+		conn, err := net.DialTimeout("udp", address, bridgeTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		var packet []byte
+		for _, line := range lines {
+			// path value becomes path:value|g
+			gauge := strings.Replace(line, " ", ":", 1) + "|g\n"
+			if len(packet)+len(gauge) > maxStatsdPacket && len(packet) > 0 {
+				if _, err := conn.Write(packet); err != nil {
+					return err
+				}
+				packet = packet[:0]
+			}
+			packet = append(packet, gauge...)
+		}
+		if len(packet) > 0 {
+			_, err = conn.Write(packet)
+		}
+		return err
+	}
+	return b, nil
+}
+
+// newBridge registers exporter_bridge_failures_total for the bridge name on
+// registry, whose metrics it sends
+func newBridge(name, prefix, labels string, registry *prometheus.Registry) (*bridge, error) {
+	switch labels {
+	case labelsAsPath, labelsAsValues, labelsAsTags:
+	default:
+		return nil, fmt.Errorf("invalid label flattening %q, expected %s, %s or %s", labels, labelsAsPath, labelsAsValues, labelsAsTags)
+	}
+	failures := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "exporter_bridge_failures_total",
+		Help:        "Number of failed sends to Graphite or StatsD, retries included.",
+		ConstLabels: prometheus.Labels{"bridge": name},
+	})
+	if err := registry.Register(failures); err != nil {
+		return nil, err
+	}
+	return &bridge{
+		name:     name,
+		gatherer: registry,
+		prefix:   strings.TrimSuffix(prefix, "."),
+		labels:   labels,
+		failures: failures,
+	}, nil
+}
+
+// run sends every interval until ctx is done, retrying failed sends with
+// backoff within the interval
+func (b *bridge) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.sendWithRetry(ctx, interval)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendWithRetry gathers once and sends, retrying with doubling backoff until
+// the next interval is due
+func (b *bridge) sendWithRetry(ctx context.Context, interval time.Duration) {
+	families, err := b.gatherer.Gather()
+	if err != nil {
+		log.Warnf("Failed gathering some metrics for %s: %v", b.name, err)
+	}
+	lines := b.lines(families)
+	deadline := time.Now().Add(interval)
+	for backoff := time.Second; ; backoff *= 2 {
+		err := b.send(lines)
+		if err == nil {
+			return
+		}
+		b.failures.Inc()
+		if time.Now().Add(backoff).After(deadline) {
+			log.Errorf("Failed sending metrics to %s: %v", b.name, err)
+			return
+		}
+		log.Warnf("Failed sending metrics to %s, retrying in %s: %v", b.name, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lines returns "path value" for every sample of families
+func (b *bridge) lines(families []*dto.MetricFamily) []string {
+	var lines []string
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			add := func(suffix string, value float64, extra ...*dto.LabelPair) {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					return
+				}
+				path := b.path(family.GetName()+suffix, append(append([]*dto.LabelPair(nil), m.GetLabel()...), extra...))
+				lines = append(lines, path+" "+strconv.FormatFloat(value, 'g', -1, 64))
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add("", q.GetValue(), labelPair("quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)))
+				}
+				add("_sum", m.GetSummary().GetSampleSum())
+				add("_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				for _, bucket := range m.GetHistogram().GetBucket() {
+					// the +Inf bucket is the sample count added below
+					if !math.IsInf(bucket.GetUpperBound(), 1) {
+						add("_bucket", float64(bucket.GetCumulativeCount()), labelPair("le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)))
+					}
+				}
+				add("_bucket", float64(m.GetHistogram().GetSampleCount()), labelPair("le", "+Inf"))
+				add("_sum", m.GetHistogram().GetSampleSum())
+				add("_count", float64(m.GetHistogram().GetSampleCount()))
+			default:
+				add("", m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return lines
+}
+
+// labelPair
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// path flattens a metric name and its labels, sorted by name
+func (b *bridge) path(name string, labels []*dto.LabelPair) string {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	var sb strings.Builder
+	if b.prefix != "" {
+		sb.WriteString(b.prefix)
+		sb.WriteByte('.')
+	}
+	sb.WriteString(name)
+	for _, l := range labels {
+		switch b.labels {
+		case labelsAsPath:
+			sb.WriteString("." + l.GetName() + "." + graphiteSafe(l.GetValue()))
+		case labelsAsValues:
+			sb.WriteString("." + graphiteSafe(l.GetValue()))
+		case labelsAsTags:
+			sb.WriteString(";" + l.GetName() + "=" + graphiteSafe(l.GetValue()))
+		}
+	}
+	return sb.String()
+}
+
+// graphiteSafe replaces the characters that separate or end Graphite path
+// components and StatsD fields
+func graphiteSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '+':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultOnceTimeout bounds the requests of scrape and -once to targets
+// without -target.timeout, so unreachable targets cannot hang them
+const defaultOnceTimeout = 10 * time.Second
+
+// command is a subcommand of the exporter
+type command struct {
+	name    string
+	summary string
+	// run runs the command with the arguments after its name and returns
+	// the exit code
+	run func(args []string) int
+}
+
+// commands are selected by the first argument, serve when it is a flag
+var commands []command
+
+func init() {
+	// assigned here since the usage of the commands lists them
+	commands = []command{
+		{"serve", "Serve the metrics of the target, the default command.", serveCommand},
+		{"validate", "Check the configuration given by the flags, including the files it names, and exit.", validateCommand},
+		{"scrape", "Collect the targets once and print their metrics in the text exposition format.", scrapeCommand},
+		{"healthcheck", "Probe a running exporter for container health checks.", healthcheckCommand},
+		{"version", "Print the version of the exporter.", versionCommand},
+	}
+}
+
+// runCommand runs the command named by the first of args and returns its
+// exit code
+func runCommand(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args)
+		}
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return 2
+}
+
+// usage lists the commands of the exporter
+func usage(out *os.File) {
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+// logFile is the file -log.output names, closed when the command returns
+var logFile *os.File
+
+// closeLogFile logs to stderr again and closes the file -log.output named
+func closeLogFile() {
+	if logFile == nil {
+		return
+	}
+	log.SetOutput(os.Stderr)
+	logFile.Close()
+	logFile = nil
+}
+
+// logFlags are the flags of every command configuring the log
+type logFlags struct {
+	level  *string
+	format *string
+	output *string
+}
+
+// newLogFlags defines the log flags on fs
+func newLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		level:  fs.String("log.level", "info", "Only log messages of this severity or above: debug, info, warn or error."),
+		format: fs.String("log.format", "logfmt", "Format of the log messages: logfmt or json."),
+		output: fs.String("log.output", "stderr", "Where the log messages go: stderr, stdout or a file they are appended to."),
+	}
+}
+
+// toConsole reports whether the log goes to stderr or stdout
+func (l *logFlags) toConsole() bool {
+	return *l.output == "stderr" || *l.output == "stdout"
+}
+
+// apply configures the standard logger
+func (l *logFlags) apply() error {
+	level, err := log.ParseLevel(*l.level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+	switch *l.format {
+	case "logfmt":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q, expected logfmt or json", *l.format)
+	}
+	switch *l.output {
+	case "stderr":
+		log.SetOutput(os.Stderr)
+	case "stdout":
+		log.SetOutput(os.Stdout)
+	default:
+		file, err := os.OpenFile(*l.output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed opening log output: %w", err)
+		}
+		logFile = file
+		log.SetOutput(file)
+	}
+	return nil
+}
+
+// parseFlags parses the arguments of the named command into fs, which exits
+// on invalid flags and -h, and applies its log flags
+func parseFlags(fs *flag.FlagSet, logs *logFlags, name string, args []string) error {
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s [flags]\n\n", os.Args[0], name)
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(out, "%s\n\n", c.summary)
+			}
+		}
+		fmt.Fprintln(out, "Flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	return logs.apply()
+}
+
+// serveCommand runs the exporter until it is stopped, scrapes its targets
+// once with -once, or controls its Windows service with -service
+func serveCommand(args []string) int {
+	if err := parseFlags(flag.CommandLine, logging, "serve", args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *once {
+		return serveOnce(flagConfig(), *onceMinUpRatio)
+	}
+	if *serviceAction != "" {
+		if err := controlService(*serviceAction, args); err != nil {
+			log.Error(err)
+			return 1
+		}
+		return 0
+	}
+	if err := runUntilStopped(flagConfig()); err != nil {
+		log.Error(err)
+		return 1
+	}
+	log.Info("Exiting")
+	return 0
+}
+
+// validateCommand sets up the exporter without starting it, which reads
+// and checks every file the flags name
+func validateCommand(args []string) int {
+	if err := parseFlags(flag.CommandLine, logging, "validate", args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inst, err := newInstance(ctx, flagConfig())
+	if err == nil {
+		err = inst.registry.Register(inst.exporter)
+	}
+	if err != nil {
+		log.Errorf("Invalid configuration: %v", err)
+		return 1
+	}
+	log.Info("Configuration is valid")
+	return 0
+}
+
+// scrapeCommand prints the metrics of one collection of the targets,
+// without the metrics of the exporter process, to debug the mapping
+func scrapeCommand(args []string) int {
+	if err := parseFlags(flag.CommandLine, logging, "scrape", args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if _, err := scrapeTargets(flagConfig()); err != nil {
+		log.Error(err)
+		return 1
+	}
+	return 0
+}
+
+// serveOnce scrapes the targets of cfg once for serve -once. It fails
+// unless minUpRatio of them were up, reporting why the others were down on
+// stderr.
+func serveOnce(cfg Config, minUpRatio float64) int {
+	if minUpRatio < 0 || minUpRatio > 1 {
+		fmt.Fprintf(os.Stderr, "-once.min-up-ratio %g is not between 0 and 1\n", minUpRatio)
+		return 2
+	}
+	inst, err := scrapeTargets(cfg)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	targets := inst.targets()
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	up := 0
+	for _, name := range names {
+		if ok, _ := targets[name].TargetUp(); ok {
+			up++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Target %s is down: %v\n", name, targets[name].LastError())
+	}
+	if ratio := float64(up) / float64(len(targets)); ratio < minUpRatio {
+		fmt.Fprintf(os.Stderr, "%d of %d targets up, fewer than -once.min-up-ratio %g\n", up, len(targets), minUpRatio)
+		return 1
+	}
+	return 0
+}
+
+// scrapeTargets collects the targets of cfg once, without starting any
+// server, and prints their metrics in the text exposition format to
+// stdout. The metrics of the exporter process are left out.
+func scrapeTargets(cfg Config) (*instance, error) {
+	cfg = oneShotConfig(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inst, err := newInstance(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := inst.registry.Register(inst.exporter); err != nil {
+		return nil, err
+	}
+	// the metrics gathered despite an error are printed as well
+	families, gatherErr := inst.registry.Gather()
+	encoder := expfmt.NewEncoder(os.Stdout, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return nil, fmt.Errorf("failed writing metrics: %w", err)
+		}
+	}
+	if gatherErr != nil {
+		return nil, fmt.Errorf("failed collecting metrics: %w", gatherErr)
+	}
+	return inst, nil
+}
+
+// oneShotConfig adapts cfg to scraping the targets once, leaving out the
+// runtime metrics and bounding requests with defaultOnceTimeout unless a
+// target timeout is set
+func oneShotConfig(cfg Config) Config {
+	cfg.DisableRuntimeMetrics = true
+	if cfg.TargetTimeout <= 0 {
+		cfg.TargetTimeout = defaultOnceTimeout
+	}
+	return cfg
+}
+
+// versionCommand prints the version of the exporter and of Go it was built
+// with
+func versionCommand(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	if err := parseFlags(fs, newLogFlags(fs), "version", args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("simple-prometheus-exporter %s %s %s/%s\n", exporterVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
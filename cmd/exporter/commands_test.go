@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLogOutput(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	for _, tc := range []struct {
+		name string
+		// output is the -log.output value, where the file written is
+		output func(file string) string
+	}{
+		{"stdout", func(string) string { return "stdout" }},
+		{"path", func(file string) string { return file }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "exporter.log")
+			// stdout is replaced by the file so its writes can be read back
+			stdout := os.Stdout
+			defer func() { os.Stdout = stdout }()
+			if tc.name == "stdout" {
+				f, err := os.Create(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				os.Stdout = f
+			}
+			fs := flag.NewFlagSet(tc.name, flag.ContinueOnError)
+			logs := newLogFlags(fs)
+			if err := fs.Parse([]string{"-log.output=" + tc.output(file)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := logs.apply(); err != nil {
+				t.Fatal(err)
+			}
+			log.Info("Written to the log output")
+			closeLogFile()
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), "Written to the log output") {
+				t.Errorf("%s holds %q, want the log message", file, content)
+			}
+		})
+	}
+}
+
+func TestServeErrorClosesLogFile(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	t.Cleanup(func() {
+		flag.Set("web.config.file", "")
+		flag.Set("log.output", "stderr")
+	})
+	dir := t.TempDir()
+	file := filepath.Join(dir, "exporter.log")
+	// a missing web config fails the exporter on start, which used to exit
+	// before the log file was closed
+	if code := run([]string{"serve", "-log.output=" + file, "-web.config.file=" + filepath.Join(dir, "missing.yml")}); code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if logFile != nil {
+		t.Error("log file left open")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "failed reading web config") {
+		t.Errorf("%s holds %q, want the error", file, content)
+	}
+}
+
+func TestOneShotConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"default", 0, defaultOnceTimeout},
+		{"configured", 3 * time.Second, 3 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := flagConfig()
+			cfg.TargetTimeout = tc.timeout
+			cfg = oneShotConfig(cfg)
+			if cfg.TargetTimeout != tc.want {
+				t.Errorf("target timeout %s, want %s", cfg.TargetTimeout, tc.want)
+			}
+			if !cfg.DisableRuntimeMetrics {
+				t.Error("runtime metrics not disabled")
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// redactedValue replaces secrets in the exposed configuration
+const redactedValue = "<redacted>"
+
+// configHandler serves cfg as JSON with credentials and query values in
+// URLs, target header values, the request body and the paths of secret
+// files redacted. Durations are shown in their string form.
+func configHandler(cfg Config) http.HandlerFunc {
+	for _, s := range []*string{&cfg.TargetURL, &cfg.RemoteWriteURL, &cfg.PushGatewayURL, &cfg.ProxyURL} {
+		*s = redactURL(*s)
+	}
+	for _, s := range []*string{
+		&cfg.TargetBody,
+		&cfg.BearerTokenFile, &cfg.BasicAuthPasswordFile, &cfg.TLSKeyFile,
+		&cfg.RemoteWriteBearerTokenFile, &cfg.RemoteWriteBasicAuthPasswordFile, &cfg.RemoteWriteKeyFile,
+	} {
+		if *s != "" {
+			*s = redactedValue
+		}
+	}
+	headers := http.Header{}
+	for name, values := range cfg.Headers {
+		for range values {
+			headers.Add(name, redactedValue)
+		}
+	}
+	cfg.Headers = headers
+
+	effective := map[string]interface{}{}
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		value := v.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		effective[v.Type().Field(i).Name] = value
+	}
+	body, err := json.MarshalIndent(effective, "", "  ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			log.Errorf("Failed encoding configuration: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// redactURL redacts the password and query values of a URL, which may hold
+// tokens, with the xxxxx of url.URL.Redacted, and all of it when it cannot
+// be parsed
+func redactURL(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedValue
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			query[name] = []string{"xxxxx"}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// instanceLabel names the discovered target on its metrics, like the
+// instance label Prometheus sets
+const instanceLabel = "instance"
+
+// targetGroup is a group of targets of a file_sd file
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// discoveredTarget is a target with the labels of its group
+type discoveredTarget struct {
+	url    string
+	labels prometheus.Labels
+}
+
+// key identifies a target and its labels, changed labels make a new target
+func (t discoveredTarget) key() string {
+	names := make([]string, 0, len(t.labels))
+	for name := range t.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString(t.url)
+	for _, name := range names {
+		fmt.Fprintf(&sb, ",%s=%q", name, t.labels[name])
+	}
+	return sb.String()
+}
+
+// discovery keeps a collector for every target of a source, a file in the
+// Prometheus file_sd format or a DNS SRV record. Targets come and go with
+// their own labels, which a registry only allows for unchecked collectors,
+// so it collects them all as one without describing any metric.
+type discovery struct {
+	mechanism string
+	// source returns the current targets by key
+	source func() (map[string]discoveredTarget, error)
+	// newCollector creates the collector of a target URL with const labels
+	newCollector func(target string, labels prometheus.Labels) (*collector.Collector, error)
+	discovered   prometheus.Gauge
+	failures     prometheus.Counter
+	// slots bounds the targets collected at once, shared by all discoveries
+	slots chan struct{}
+
+	mu         sync.Mutex
+	collectors map[string]*collector.Collector
+}
+
+// newDiscovery registers the discovery with exporter_discovered_targets and
+// exporter_discovery_failures_total for mechanism on registry. It starts
+// without targets until refreshed, and collects a target only while it
+// holds one of slots.
+func newDiscovery(mechanism string, source func() (map[string]discoveredTarget, error), registry *prometheus.Registry, slots chan struct{}, newCollector func(string, prometheus.Labels) (*collector.Collector, error)) (*discovery, error) {
+	d := &discovery{
+		mechanism:    mechanism,
+		source:       source,
+		newCollector: newCollector,
+		slots:        slots,
+		discovered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "exporter_discovered_targets",
+			Help:        "Number of targets currently discovered.",
+			ConstLabels: prometheus.Labels{"mechanism": mechanism},
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "exporter_discovery_failures_total",
+			Help:        "Number of failed refreshes of the discovered targets, which keep the previous targets.",
+			ConstLabels: prometheus.Labels{"mechanism": mechanism},
+		}),
+		collectors: map[string]*collector.Collector{},
+	}
+	for _, c := range []prometheus.Collector{d.discovered, d.failures, d} {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Describe describes nothing, which makes the discovery unchecked
+func (d *discovery) Describe(chan<- *prometheus.Desc) {}
+
+// Collect collects the discovered targets concurrently, as many at once as
+// there are slots, the others waiting for a free one
+func (d *discovery) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, c := range d.targets() {
+		wg.Add(1)
+		go func(c *collector.Collector) {
+			defer wg.Done()
+			d.slots <- struct{}{}
+			defer func() { <-d.slots }()
+			c.Collect(ch)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// targets returns the collectors of the discovered targets by key
+func (d *discovery) targets() map[string]*collector.Collector {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	targets := make(map[string]*collector.Collector, len(d.collectors))
+	for key, c := range d.collectors {
+		targets[key] = c
+	}
+	return targets
+}
+
+// run refreshes every interval and when changed receives, until ctx is
+// done, keeping the current targets when the source fails
+func (d *discovery) run(ctx context.Context, interval time.Duration, changed <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := d.refresh(); err != nil {
+			log.Errorf("Failed refreshing %s discovery, keeping the current targets: %v", d.mechanism, err)
+		}
+	}
+}
+
+// refresh reads the source and creates and drops collectors for the
+// targets that appeared and disappeared
+func (d *discovery) refresh() error {
+	targets, err := d.source()
+	if err != nil {
+		d.failures.Inc()
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.collectors {
+		if _, ok := targets[key]; !ok {
+			delete(d.collectors, key)
+			log.Infof("Dropped discovered target %s", key)
+		}
+	}
+	for key, target := range targets {
+		if _, ok := d.collectors[key]; ok {
+			continue
+		}
+		c, err := d.newCollector(target.url, target.labels)
+		if err != nil {
+			log.Errorf("Skipping discovered target %s: %v", key, err)
+			continue
+		}
+		d.collectors[key] = c
+		log.Infof("Discovered target %s", key)
+	}
+	d.discovered.Set(float64(len(d.collectors)))
+	return nil
+}
+
+// watchFile signals changes of file until ctx is done. Its directory is
+// watched since editors and config management replace files by renaming
+// them over the old one.
+func watchFile(ctx context.Context, file string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event := <-watcher.Events:
+				if filepath.Clean(event.Name) != filepath.Clean(file) {
+					continue
+				}
+				select {
+				case changed <- struct{}{}:
+				default:
+					// a refresh is pending already
+				}
+			case err := <-watcher.Errors:
+				log.Warnf("Failed watching discovery file %s: %v", file, err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changed, nil
+}
+
+// kubernetesResync re-lists the EndpointSlices in case a watch missed changes
+const kubernetesResync = 5 * time.Minute
+
+// srvLookupTimeout bounds a lookup of the SRV record
+const srvLookupTimeout = 10 * time.Second
+
+// srvResolver looks up SRV records, *net.Resolver satisfies it
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// srvTargets returns a source of the targets of the SRV record name, URLs of
+// scheme with the host and port of each record and path
+func srvTargets(resolver srvResolver, name, scheme, path string) func() (map[string]discoveredTarget, error) {
+	return func() (map[string]discoveredTarget, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+		defer cancel()
+		_, records, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		targets := map[string]discoveredTarget{}
+		for _, record := range records {
+			address := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+			target := discoveredTarget{url: scheme + "://" + address + path, labels: prometheus.Labels{instanceLabel: address}}
+			targets[target.key()] = target
+		}
+		return targets, nil
+	}
+}
+
+// readDiscoveryFile parses a JSON or YAML file_sd file into targets by key.
+// Targets are host:port, scraped over the scheme of a __scheme__ label or
+// http, and labelled with the instance and the labels of their group but
+// those starting with __.
+func readDiscoveryFile(file string) (map[string]discoveredTarget, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading discovery file: %w", err)
+	}
+	var groups []targetGroup
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(content, &groups)
+	default:
+		err = json.Unmarshal(content, &groups)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing discovery file %s: %w", file, err)
+	}
+	targets := map[string]discoveredTarget{}
+	for _, group := range groups {
+		scheme := "http"
+		labels := prometheus.Labels{}
+		for name, value := range group.Labels {
+			if !model.LabelName(name).IsValid() {
+				return nil, fmt.Errorf("invalid label name %q in discovery file", name)
+			}
+			switch {
+			case name == model.SchemeLabel:
+				scheme = value
+			case name == instanceLabel:
+				return nil, fmt.Errorf("label %q is set from the target in discovery file", instanceLabel)
+			case !strings.HasPrefix(name, model.ReservedLabelPrefix):
+				labels[name] = value
+			}
+		}
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("invalid %s %q in discovery file", model.SchemeLabel, scheme)
+		}
+		for _, address := range group.Targets {
+			u, err := url.Parse(scheme + "://" + address)
+			if err != nil || u.Host != address {
+				return nil, fmt.Errorf("invalid target %q in discovery file, expected host:port", address)
+			}
+			target := discoveredTarget{url: u.String(), labels: prometheus.Labels{instanceLabel: address}}
+			for name, value := range labels {
+				target.labels[name] = value
+			}
+			targets[target.key()] = target
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// targetLabels returns the labels of targets by URL
+func targetLabels(targets map[string]discoveredTarget) map[string]prometheus.Labels {
+	out := make(map[string]prometheus.Labels, len(targets))
+	for _, target := range targets {
+		out[target.url] = target.labels
+	}
+	return out
+}
+
+func TestReadDiscoveryFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]prometheus.Labels
+		err     string
+	}{
+		{
+			name:    "json",
+			file:    "targets.json",
+			content: `[{"targets": ["a:8080", "b:8080"], "labels": {"env": "prod", "__meta_zone": "eu"}}]`,
+			want: map[string]prometheus.Labels{
+				"http://a:8080": {"instance": "a:8080", "env": "prod"},
+				"http://b:8080": {"instance": "b:8080", "env": "prod"},
+			},
+		},
+		{
+			name:    "yaml_with_scheme",
+			file:    "targets.yml",
+			content: "- targets: ['[::1]:8443']\n  labels: {__scheme__: https}\n- targets: ['c:80']\n",
+			want: map[string]prometheus.Labels{
+				"https://[::1]:8443": {"instance": "[::1]:8443"},
+				"http://c:80":        {"instance": "c:80"},
+			},
+		},
+		{name: "empty", file: "targets.json", content: `[]`, want: map[string]prometheus.Labels{}},
+		{name: "invalid_json", file: "targets.json", content: `[{"targets": "a:8080"}]`, err: "failed parsing discovery file"},
+		{name: "unknown_yaml_field", file: "targets.yaml", content: "- targets: ['a:1']\n  label: {}\n", err: "failed parsing discovery file"},
+		{name: "invalid_label_name", file: "targets.json", content: `[{"targets": ["a:1"], "labels": {"0env": "x"}}]`, err: `invalid label name "0env"`},
+		{name: "instance_label", file: "targets.json", content: `[{"targets": ["a:1"], "labels": {"instance": "x"}}]`, err: `label "instance" is set from the target`},
+		{name: "invalid_scheme", file: "targets.json", content: `[{"targets": ["a:1"], "labels": {"__scheme__": "ftp"}}]`, err: `invalid __scheme__ "ftp"`},
+		{name: "target_with_path", file: "targets.json", content: `[{"targets": ["a:1/stats"]}]`, err: `invalid target "a:1/stats"`},
+		{name: "target_with_scheme", file: "targets.json", content: `[{"targets": ["http://a:1"]}]`, err: `invalid target "http://a:1"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tc.file)
+			if err := os.WriteFile(file, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			targets, err := readDiscoveryFile(file)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want one containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := targetLabels(targets); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("targets = %v, want %v", got, tc.want)
+			}
+		})
+	}
+	if _, err := readDiscoveryFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+}
+
+func TestDiscoveryRefresh(t *testing.T) {
+	target := func(address string, labels prometheus.Labels) discoveredTarget {
+		target := discoveredTarget{url: "http://" + address, labels: prometheus.Labels{instanceLabel: address}}
+		for name, value := range labels {
+			target.labels[name] = value
+		}
+		return target
+	}
+	targets := func(ts ...discoveredTarget) map[string]discoveredTarget {
+		out := map[string]discoveredTarget{}
+		for _, t := range ts {
+			out[t.key()] = t
+		}
+		return out
+	}
+	var (
+		current map[string]discoveredTarget
+		failing error
+		created []string
+	)
+	registry := prometheus.NewRegistry()
+	d, err := newDiscovery("file", func() (map[string]discoveredTarget, error) {
+		return current, failing
+	}, registry, make(chan struct{}, 1), func(url string, labels prometheus.Labels) (*collector.Collector, error) {
+		created = append(created, url)
+		return collector.NewCollector(url, collector.WithConstLabels(labels))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		name    string
+		targets map[string]discoveredTarget
+		fail    bool
+		// want are the discovered keys, created the URLs of new collectors
+		want     []discoveredTarget
+		created  []string
+		failures float64
+	}{
+		{name: "initial", targets: targets(target("a:1", nil), target("b:1", nil)), want: []discoveredTarget{target("a:1", nil), target("b:1", nil)}, created: []string{"http://a:1", "http://b:1"}},
+		{name: "unchanged", targets: targets(target("a:1", nil), target("b:1", nil)), want: []discoveredTarget{target("a:1", nil), target("b:1", nil)}},
+		{name: "source_fails", fail: true, want: []discoveredTarget{target("a:1", nil), target("b:1", nil)}, failures: 1},
+		{name: "dropped_and_added", targets: targets(target("b:1", nil), target("c:1", nil)), want: []discoveredTarget{target("b:1", nil), target("c:1", nil)}, created: []string{"http://c:1"}, failures: 1},
+		{name: "labels_changed", targets: targets(target("b:1", prometheus.Labels{"env": "prod"})), want: []discoveredTarget{target("b:1", prometheus.Labels{"env": "prod"})}, created: []string{"http://b:1"}, failures: 1},
+		{name: "invalid_target_skipped", targets: targets(target("b:1", prometheus.Labels{"env": "prod"}), target("bad:port", nil)), want: []discoveredTarget{target("b:1", prometheus.Labels{"env": "prod"})}, created: []string{"http://bad:port"}, failures: 1},
+	} {
+		current, failing, created = step.targets, nil, nil
+		if step.fail {
+			failing = errors.New("unreadable")
+		}
+		if err := d.refresh(); (err != nil) != step.fail {
+			t.Errorf("%s: refresh error = %v", step.name, err)
+		}
+		collectors := d.targets()
+		if len(collectors) != len(step.want) {
+			t.Errorf("%s: %d targets, want %d", step.name, len(collectors), len(step.want))
+		}
+		for _, want := range step.want {
+			if collectors[want.key()] == nil {
+				t.Errorf("%s: %s not discovered", step.name, want.key())
+			}
+		}
+		// new targets are created in map order
+		sort.Strings(created)
+		if !reflect.DeepEqual(created, step.created) {
+			t.Errorf("%s: created collectors for %v, want %v", step.name, created, step.created)
+		}
+		if got := testutil.ToFloat64(d.discovered); got != float64(len(step.want)) {
+			t.Errorf("%s: exporter_discovered_targets = %v, want %d", step.name, got, len(step.want))
+		}
+		if got := testutil.ToFloat64(d.failures); got != step.failures {
+			t.Errorf("%s: exporter_discovery_failures_total = %v, want %v", step.name, got, step.failures)
+		}
+	}
+}
+
+func TestDiscoveryCollect(t *testing.T) {
+	targets := map[string]discoveredTarget{}
+	for _, body := range []string{`{"http200Requestcounter": 1}`, `{"http200Requestcounter": 2}`} {
+		body := body
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		defer server.Close()
+		address := strings.TrimPrefix(server.URL, "http://")
+		target := discoveredTarget{url: server.URL, labels: prometheus.Labels{instanceLabel: address}}
+		targets[target.key()] = target
+	}
+	registry := prometheus.NewRegistry()
+	d, err := newDiscovery("file", func() (map[string]discoveredTarget, error) {
+		return targets, nil
+	}, registry, make(chan struct{}, 1), func(url string, labels prometheus.Labels) (*collector.Collector, error) {
+		return collector.NewCollector(url, collector.WithConstLabels(labels))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "http_request_200counter" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == instanceLabel {
+					counters[label.GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	if len(counters) != 2 {
+		t.Fatalf("counters by instance = %v, want one per target", counters)
+	}
+	for _, target := range targets {
+		instance := target.labels[instanceLabel]
+		if counters[instance] == 0 {
+			t.Errorf("no counter of %s", instance)
+		}
+	}
+}
+
+// fakeSRVResolver answers every lookup with records or err
+type fakeSRVResolver struct {
+	records []*net.SRV
+	err     error
+	names   []string
+}
+
+// LookupSRV
+func (r *fakeSRVResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.names = append(r.names, name)
+	return name, r.records, r.err
+}
+
+func TestSRVTargets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		records []*net.SRV
+		err     error
+		scheme  string
+		path    string
+		want    map[string]prometheus.Labels
+	}{
+		{
+			name:    "records",
+			records: []*net.SRV{{Target: "a.example.com.", Port: 8080}, {Target: "b.example.com.", Port: 9090}},
+			scheme:  "http",
+			want: map[string]prometheus.Labels{
+				"http://a.example.com:8080": {"instance": "a.example.com:8080"},
+				"http://b.example.com:9090": {"instance": "b.example.com:9090"},
+			},
+		},
+		{
+			name:    "scheme_and_path",
+			records: []*net.SRV{{Target: "a.example.com.", Port: 8443}},
+			scheme:  "https",
+			path:    "/base",
+			want:    map[string]prometheus.Labels{"https://a.example.com:8443/base": {"instance": "a.example.com:8443"}},
+		},
+		{
+			name:    "ipv6_target",
+			records: []*net.SRV{{Target: "::1", Port: 8080}},
+			scheme:  "http",
+			want:    map[string]prometheus.Labels{"http://[::1]:8080": {"instance": "[::1]:8080"}},
+		},
+		{name: "no_records", scheme: "http", want: map[string]prometheus.Labels{}},
+		{name: "lookup_fails", err: &net.DNSError{Err: "no such host", Name: "_stats._tcp.example.com", IsNotFound: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver := &fakeSRVResolver{records: tc.records, err: tc.err}
+			targets, err := srvTargets(resolver, "_stats._tcp.example.com", tc.scheme, tc.path)()
+			if !reflect.DeepEqual(resolver.names, []string{"_stats._tcp.example.com"}) {
+				t.Errorf("looked up %v", resolver.names)
+			}
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Errorf("error = %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := targetLabels(targets); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("targets = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryMaxConcurrency(t *testing.T) {
+	const maxConcurrency = 3
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"http200Requestcounter": 1}`))
+	}))
+	defer server.Close()
+	targets := map[string]discoveredTarget{}
+	for i := 0; i < 20; i++ {
+		target := discoveredTarget{
+			url:    fmt.Sprintf("%s/target%d", server.URL, i),
+			labels: prometheus.Labels{instanceLabel: fmt.Sprintf("target%d", i)},
+		}
+		targets[target.key()] = target
+	}
+	registry := prometheus.NewRegistry()
+	d, err := newDiscovery("file", func() (map[string]discoveredTarget, error) {
+		return targets, nil
+	}, registry, make(chan struct{}, maxConcurrency), func(url string, labels prometheus.Labels) (*collector.Collector, error) {
+		return collector.NewCollector(url, collector.WithConstLabels(labels))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	if max := atomic.LoadInt32(&maxInFlight); max > maxConcurrency || max < 2 {
+		t.Errorf("at most %d targets fetched at once, want between 2 and %d", max, maxConcurrency)
+	}
+}
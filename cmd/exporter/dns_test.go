@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNSCacheDial(t *testing.T) {
+	for _, network := range []string{"tcp4", "tcp6"} {
+		t.Run(network, func(t *testing.T) {
+			loopback := map[string]string{"tcp4": "127.0.0.1", "tcp6": "::1"}[network]
+			listener, err := net.Listen(network, net.JoinHostPort(loopback, "0"))
+			if err != nil {
+				t.Skipf("no %s loopback: %v", network, err)
+			}
+			defer listener.Close()
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					conn.Close()
+				}
+			}()
+			_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+			lookups := 0
+			cache := newDNSCache(time.Minute)
+			cache.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+				lookups++
+				return []net.IPAddr{{IP: net.ParseIP(loopback)}}, nil
+			}
+			// literals, bracketed for IPv6, are dialed without a lookup
+			for _, addr := range []string{listener.Addr().String(), net.JoinHostPort("target.test", port), net.JoinHostPort("target.test", port)} {
+				conn, err := cache.DialContext(context.Background(), "tcp", addr)
+				if err != nil {
+					t.Fatalf("dialing %s: %v", addr, err)
+				}
+				conn.Close()
+			}
+			if lookups != 1 {
+				t.Errorf("%d lookups, want 1 for the cached name", lookups)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// healthcheckCommand probes /-/healthy, or /-/ready, of a running exporter
+// for container health checks. It prints nothing and returns 0 when the
+// exporter answers 200, else the reason and 1.
+func healthcheckCommand(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	logs := newLogFlags(fs)
+	address := fs.String("web.listen-address", promhttpAddr, "Address the metrics server of the exporter listens on, probed on localhost when it has no host.")
+	target := fs.String("url", "", "URL to probe instead of /-/healthy on -web.listen-address.")
+	ready := fs.Bool("ready", false, "Probe /-/ready instead of /-/healthy.")
+	timeout := fs.Duration("timeout", 2*time.Second, "Timeout of the probe.")
+	webConfig := fs.String("web.config.file", "", "Web config file of the exporter, probing over TLS and trusting its certificate when it sets tls_server_config.")
+	insecure := fs.Bool("tls.insecure-skip-verify", false, "Skip verifying the certificate of the exporter, which may not be issued for localhost.")
+	username := fs.String("username", "", "User to authenticate as when the web config sets basic_auth_users.")
+	passwordFile := fs.String("password-file", "", "File with the password of -username.")
+	if err := parseFlags(fs, logs, "healthcheck", args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	scheme := "http"
+	if *webConfig != "" {
+		config, err := probeTLSConfig(*webConfig, *insecure)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if config != nil {
+			scheme, transport.TLSClientConfig = "https", config
+		}
+	} else if *insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	probe := *target
+	if probe == "" {
+		path := "/-/healthy"
+		if *ready {
+			path = "/-/ready"
+		}
+		host, err := probeAddress(*address)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		probe = scheme + "://" + host + path
+	}
+	request, err := http.NewRequest(http.MethodGet, probe, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *username != "" {
+		var password []byte
+		if *passwordFile != "" {
+			if password, err = os.ReadFile(*passwordFile); err != nil {
+				fmt.Fprintf(os.Stderr, "failed reading password file: %v\n", err)
+				return 1
+			}
+		}
+		request.SetBasicAuth(*username, strings.TrimSpace(string(password)))
+	}
+	client := &http.Client{Timeout: *timeout, Transport: transport}
+	response, err := client.Do(request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		fmt.Fprintf(os.Stderr, "%s returned HTTP status %s: %s\n", probe, response.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+	return 0
+}
+
+// probeAddress returns the address to probe a server listening on address,
+// on localhost when it listens on all interfaces
+func probeAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHealthcheckCommand(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, "server")
+	otherCert, otherKey, _ := writeCert(t, dir, "other")
+	tlsConfig := writeWebConfig(t, t.TempDir(), "tls_server_config:\n  cert_file: "+certFile+"\n  key_file: "+keyFile+"\n")
+	otherConfig := writeWebConfig(t, t.TempDir(), "tls_server_config:\n  cert_file: "+otherCert+"\n  key_file: "+otherKey+"\n")
+	plainConfig := writeWebConfig(t, t.TempDir(), "")
+	healthy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/-/healthy" {
+			http.NotFound(w, r)
+		}
+	})
+	plain := httptest.NewServer(healthy)
+	defer plain.Close()
+	web, err := loadWebConfig(tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secure := httptest.NewUnstartedServer(healthy)
+	secure.TLS = web.serverTLSConfig()
+	secure.StartTLS()
+	defer secure.Close()
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig, err := loadWebConfig(writeWebConfig(t, t.TempDir(), "basic_auth_users:\n  admin: "+string(hash)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticated := httptest.NewServer(authConfig.handler(healthy))
+	defer authenticated.Close()
+	passwordFile, wrongFile := filepath.Join(dir, "password"), filepath.Join(dir, "wrong")
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(wrongFile, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		server *httptest.Server
+		args   []string
+		code   int
+	}{
+		{name: "http", server: plain},
+		{name: "http_with_web_config", server: plain, args: []string{"-web.config.file=" + plainConfig}},
+		{name: "https_without_web_config", server: secure, code: 1},
+		{name: "https", server: secure, args: []string{"-web.config.file=" + tlsConfig}},
+		{name: "https_untrusted", server: secure, args: []string{"-web.config.file=" + otherConfig}, code: 1},
+		{name: "https_insecure", server: secure, args: []string{"-web.config.file=" + otherConfig, "-tls.insecure-skip-verify"}},
+		{name: "missing_web_config", server: plain, args: []string{"-web.config.file=" + dir + "/missing.yml"}, code: 1},
+		{name: "basic_auth_without_credentials", server: authenticated, code: 1},
+		{name: "basic_auth", server: authenticated, args: []string{"-username=admin", "-password-file=" + passwordFile}},
+		{name: "basic_auth_wrong_password", server: authenticated, args: []string{"-username=admin", "-password-file=" + wrongFile}, code: 1},
+		{name: "basic_auth_missing_password_file", server: authenticated, args: []string{"-username=admin", "-password-file=" + dir + "/missing"}, code: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"-web.listen-address=" + tc.server.Listener.Addr().String()}, tc.args...)
+			if code := healthcheckCommand(args); code != tc.code {
+				t.Errorf("healthcheck %v = %d, want %d", args, code, tc.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// instrumentHandler registers the exporter_http_* metrics of next on registry
+// and returns next observed by them
+func instrumentHandler(registry prometheus.Registerer, next http.Handler) http.Handler {
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_http_requests_in_flight",
+		Help: "Number of scrapes of the exporter being served.",
+	})
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exporter_http_requests_total",
+		Help: "Number of scrapes of the exporter by HTTP status code.",
+	}, []string{"code"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "exporter_http_request_duration_seconds",
+		Help:    "Duration of scrapes of the exporter.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"code"})
+	responseSize := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "exporter_http_response_size_bytes",
+		Help:    "Size of the responses to scrapes of the exporter.",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+	}, nil)
+	registry.MustRegister(inFlight, requests, duration, responseSize)
+	return promhttp.InstrumentHandlerInFlight(inFlight,
+		promhttp.InstrumentHandlerCounter(requests,
+			promhttp.InstrumentHandlerDuration(duration,
+				promhttp.InstrumentHandlerResponseSize(responseSize, next))))
+}
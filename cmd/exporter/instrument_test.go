@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	var inFlight []float64
+	handler := instrumentHandler(registry, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Error(err)
+		}
+		for _, family := range families {
+			if family.GetName() == "exporter_http_requests_in_flight" {
+				inFlight = append(inFlight, family.GetMetric()[0].GetGauge().GetValue())
+			}
+		}
+		w.Write([]byte("metrics"))
+	}))
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	}
+
+	if len(inFlight) != 2 || inFlight[0] != 1 || inFlight[1] != 1 {
+		t.Errorf("in flight during the scrapes %v, want [1 1]", inFlight)
+	}
+	expected := `
+# HELP exporter_http_requests_in_flight Number of scrapes of the exporter being served.
+# TYPE exporter_http_requests_in_flight gauge
+exporter_http_requests_in_flight 0
+# HELP exporter_http_requests_total Number of scrapes of the exporter by HTTP status code.
+# TYPE exporter_http_requests_total counter
+exporter_http_requests_total{code="200"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "exporter_http_requests_in_flight", "exporter_http_requests_total"); err != nil {
+		t.Error(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		switch family.GetName() {
+		case "exporter_http_request_duration_seconds", "exporter_http_response_size_bytes":
+			if count := family.GetMetric()[0].GetHistogram().GetSampleCount(); count != 2 {
+				t.Errorf("%s counts %d scrapes, want 2", family.GetName(), count)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// jsonFamily is a metric family in the JSON form of /metrics.json, the
+// shape of prom2json. Values are strings since JSON has no NaN and Inf.
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+type jsonMetric struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	// Value of counters, gauges and untyped metrics
+	Value string `json:"value,omitempty"`
+	// Quantiles of summaries
+	Quantiles map[string]string `json:"quantiles,omitempty"`
+	// Buckets of histograms by upper bound, cumulative
+	Buckets map[string]string `json:"buckets,omitempty"`
+	// Count and Sum of summaries and histograms
+	Count string `json:"count,omitempty"`
+	Sum   string `json:"sum,omitempty"`
+}
+
+// jsonMetricsHandler serves the metrics of gatherer as JSON, only the
+// families given by ?name= when there are any
+func jsonMetricsHandler(gatherer prometheus.Gatherer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			// Gather returns what it could gather along with the error
+			log.Warnf("Failed gathering some metrics for JSON: %v", err)
+		}
+		names := map[string]bool{}
+		for _, name := range r.URL.Query()["name"] {
+			names[name] = true
+		}
+		out := []jsonFamily{}
+		for _, family := range families {
+			if len(names) == 0 || names[family.GetName()] {
+				out = append(out, toJSONFamily(family))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(out); err != nil {
+			log.Errorf("Failed writing JSON metrics: %v", err)
+		}
+	}
+}
+
+// toJSONFamily
+func toJSONFamily(family *dto.MetricFamily) jsonFamily {
+	f := jsonFamily{
+		Name:    family.GetName(),
+		Help:    family.GetHelp(),
+		Type:    strings.ToLower(family.GetType().String()),
+		Metrics: make([]jsonMetric, 0, len(family.GetMetric())),
+	}
+	for _, m := range family.GetMetric() {
+		metric := jsonMetric{}
+		if len(m.GetLabel()) > 0 {
+			metric.Labels = map[string]string{}
+			for _, l := range m.GetLabel() {
+				metric.Labels[l.GetName()] = l.GetValue()
+			}
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Value = jsonFloat(m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			metric.Value = jsonFloat(m.GetGauge().GetValue())
+		case dto.MetricType_SUMMARY:
+			metric.Quantiles = map[string]string{}
+			for _, q := range m.GetSummary().GetQuantile() {
+				metric.Quantiles[jsonFloat(q.GetQuantile())] = jsonFloat(q.GetValue())
+			}
+			metric.Count = strconv.FormatUint(m.GetSummary().GetSampleCount(), 10)
+			metric.Sum = jsonFloat(m.GetSummary().GetSampleSum())
+		case dto.MetricType_HISTOGRAM:
+			metric.Buckets = map[string]string{}
+			for _, b := range m.GetHistogram().GetBucket() {
+				metric.Buckets[jsonFloat(b.GetUpperBound())] = strconv.FormatUint(b.GetCumulativeCount(), 10)
+			}
+			metric.Buckets["+Inf"] = strconv.FormatUint(m.GetHistogram().GetSampleCount(), 10)
+			metric.Count = strconv.FormatUint(m.GetHistogram().GetSampleCount(), 10)
+			metric.Sum = jsonFloat(m.GetHistogram().GetSampleSum())
+		default:
+			metric.Value = jsonFloat(m.GetUntyped().GetValue())
+		}
+		f.Metrics = append(f.Metrics, metric)
+	}
+	return f
+}
+
+// jsonFloat formats f like the text format
+func jsonFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata.")
+
+// jsonTestRegistry holds a metric of every type with fixed values
+func jsonTestRegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_requests_total",
+		Help: "Requests by code.",
+	}, []string{"code"})
+	requests.WithLabelValues("200").Add(42)
+	requests.WithLabelValues("500").Add(3)
+	temperature := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "test_temperature",
+		Help: "Gauge with values JSON has no numbers for.",
+	}, []string{"sensor"})
+	temperature.WithLabelValues("nan").Set(math.NaN())
+	temperature.WithLabelValues("hot").Set(math.Inf(1))
+	temperature.WithLabelValues("cold").Set(-12.5)
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "test_duration_seconds",
+		Help:    "Histogram.",
+		Buckets: []float64{0.1, 1},
+	})
+	size := prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "test_size_bytes",
+		Help:       "Summary.",
+		Objectives: map[float64]float64{0.5: 0.05},
+	})
+	for _, v := range []float64{0.05, 0.5, 0.5, 3} {
+		duration.Observe(v)
+		size.Observe(v * 100)
+	}
+	untyped := prometheus.NewUntypedFunc(prometheus.UntypedOpts{
+		Name: "test_untyped",
+		Help: "Untyped.",
+	}, func() float64 { return 7 })
+	registry.MustRegister(requests, temperature, duration, size, untyped)
+	return registry
+}
+
+func TestJSONMetricsGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		query string
+	}{
+		{"all", ""},
+		{"one_name", "?name=test_requests_total"},
+		{"two_names", "?name=test_duration_seconds&name=test_temperature"},
+		{"unknown_name", "?name=test_missing"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			jsonMetricsHandler(jsonTestRegistry(t))(recorder, httptest.NewRequest(http.MethodGet, "/metrics.json"+tc.query, nil))
+			if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", contentType)
+			}
+			golden := filepath.Join("testdata", "metrics_json", tc.name+".json")
+			if *update {
+				if err := os.WriteFile(golden, recorder.Body.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := recorder.Body.String(); got != string(expected) {
+				t.Errorf("body differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestJSONFloat(t *testing.T) {
+	for _, tc := range []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{-12.5, "-12.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	} {
+		if got := jsonFloat(tc.in); got != tc.want {
+			t.Errorf("jsonFloat(%v) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+//go:build kubernetes
+// +build kubernetes
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceAccountDir holds the credentials and namespace of the pod's
+// service account, which needs list and watch on endpointslices
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesClient lists and watches the EndpointSlices of the pod's
+// namespace matching a label selector
+type kubernetesClient struct {
+	server    string
+	namespace string
+	selector  string
+	client    *http.Client
+}
+
+// endpointSliceList is the part of a discovery.k8s.io/v1 EndpointSliceList
+// the discovery reads
+type endpointSliceList struct {
+	Items []endpointSlice `json:"items"`
+}
+
+type endpointSlice struct {
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int32  `json:"port"`
+	} `json:"ports"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+		NodeName  *string `json:"nodeName"`
+		TargetRef *struct {
+			Kind      string `json:"kind"`
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"targetRef"`
+	} `json:"endpoints"`
+}
+
+// kubernetesTargets returns a source of the ready endpoints of the
+// EndpointSlices matching selector on port, a port name or number or the
+// first port when empty, and signals changes watched until ctx is done
+func kubernetesTargets(ctx context.Context, selector, port string) (func() (map[string]discoveredTarget, error), <-chan struct{}, error) {
+	k, err := newKubernetesClient(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed := make(chan struct{}, 1)
+	go k.watch(ctx, changed)
+	return func() (map[string]discoveredTarget, error) { return k.list(port) }, changed, nil
+}
+
+// newKubernetesClient connects to the API server of the cluster the pod runs in
+func newKubernetesClient(selector string) (*kubernetesClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes discovery only works in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading namespace of the service account: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CA of the service account: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no PEM certificates found in the CA of the service account")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &kubernetesClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		selector:  selector,
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// get requests the matching EndpointSlices with further query parameters
+func (k *kubernetesClient) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	query.Set("labelSelector", k.selector)
+	endpoint := k.server + "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(k.namespace) + "/endpointslices?" + query.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	// re-read on every request since projected tokens are rotated
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading service account token: %w", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	request.Header.Set("Accept", "application/json")
+	response, err := k.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return nil, fmt.Errorf("API server returned HTTP status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return response, nil
+}
+
+// list returns the ready endpoints of the matching EndpointSlices on port,
+// labelled with their pod, namespace and node
+func (k *kubernetesClient) list(port string) (map[string]discoveredTarget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	response, err := k.get(ctx, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var slices endpointSliceList
+	if err := json.NewDecoder(response.Body).Decode(&slices); err != nil {
+		return nil, fmt.Errorf("failed decoding EndpointSlices: %w", err)
+	}
+	targets := map[string]discoveredTarget{}
+	for _, slice := range slices.Items {
+		number, ok := slicePort(slice, port)
+		if !ok {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			// unknown readiness counts as ready
+			if ready := endpoint.Conditions.Ready; ready != nil && !*ready {
+				continue
+			}
+			labels := prometheus.Labels{"namespace": k.namespace}
+			if ref := endpoint.TargetRef; ref != nil && ref.Kind == "Pod" {
+				labels["pod"] = ref.Name
+				labels["namespace"] = ref.Namespace
+			}
+			if endpoint.NodeName != nil {
+				labels["node"] = *endpoint.NodeName
+			}
+			for _, address := range endpoint.Addresses {
+				instance := net.JoinHostPort(address, strconv.Itoa(int(number)))
+				target := discoveredTarget{url: "http://" + instance, labels: prometheus.Labels{instanceLabel: instance}}
+				for name, value := range labels {
+					target.labels[name] = value
+				}
+				targets[target.key()] = target
+			}
+		}
+	}
+	return targets, nil
+}
+
+// slicePort finds port by name or number in slice, the first port when empty
+func slicePort(slice endpointSlice, port string) (int32, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if port == "" || p.Name != nil && *p.Name == port || strconv.Itoa(int(*p.Port)) == port {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// watch signals changes of the matching EndpointSlices until ctx is done,
+// reconnecting with backoff when the watch fails
+func (k *kubernetesClient) watch(ctx context.Context, changed chan<- struct{}) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := k.watchOnce(ctx, changed)
+		if err == nil {
+			// the server ended the watch after its timeout
+			backoff = time.Second
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warnf("Failed watching EndpointSlices, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// watchOnce signals every event of one watch request
+func (k *kubernetesClient) watchOnce(ctx context.Context, changed chan<- struct{}) error {
+	response, err := k.get(ctx, url.Values{"watch": {"1"}, "timeoutSeconds": {"300"}})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("watch error: %s", event.Object)
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+			// a refresh is pending already
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !kubernetes
+// +build !kubernetes
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// kubernetesTargets is only available in builds with the kubernetes tag
+func kubernetesTargets(context.Context, string, string) (func() (map[string]discoveredTarget, error), <-chan struct{}, error) {
+	return nil, nil, errors.New("kubernetes discovery needs an exporter built with -tags kubernetes")
+}
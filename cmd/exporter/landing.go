@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"runtime/debug"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>simple-prometheus-exporter</title></head>
+<body>
+<h1>simple-prometheus-exporter</h1>
+<p>Version {{.Version}}</p>
+<ul>
+<li><a href="/metrics">/metrics</a></li>
+<li><a href="/metrics.json">/metrics.json</a></li>
+<li><a href="/-/healthy">/-/healthy</a></li>
+<li><a href="/-/ready">/-/ready</a></li>
+{{- if .Config}}
+<li><a href="/-/config">/-/config</a></li>
+{{- end}}
+{{- if .Scrape}}
+<li><a href="/-/scrape">/-/scrape</a></li>
+{{- end}}
+{{- if .Quit}}
+<li>/-/quit (POST)</li>
+{{- end}}
+{{- if .Pprof}}
+<li><a href="/debug/pprof/">/debug/pprof/</a></li>
+{{- end}}
+</ul>
+<h2>Targets</h2>
+<table>
+<tr><th>Target</th><th>Status</th></tr>
+{{- range .Targets}}
+<tr><td>{{.Name}}</td><td>{{.Status}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// landingPage serves an overview of the exporter on /
+type landingPage struct {
+	// the optional endpoints of the metrics server that are enabled
+	config, scrape, quit, pprof bool
+	// targets returns the collectors of the target and the discovered
+	// targets by name
+	targets func() map[string]*collector.Collector
+}
+
+// landingTarget is a row of the targets table
+type landingTarget struct {
+	Name, Status string
+}
+
+// newLandingPage lists the endpoints enabled by cfg and the current targets
+func newLandingPage(cfg Config, targets func() map[string]*collector.Collector) *landingPage {
+	return &landingPage{
+		config:  cfg.ExposeConfig,
+		scrape:  cfg.EnableScrapeEndpoint,
+		quit:    cfg.EnableLifecycle,
+		pprof:   cfg.EnablePprof,
+		targets: targets,
+	}
+}
+
+// ServeHTTP
+func (p *landingPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the mux routes every unknown path to /
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	collectors := p.targets()
+	targets := make([]landingTarget, 0, len(collectors))
+	for name, c := range collectors {
+		status := "unknown"
+		if up, scraped := c.TargetUp(); scraped && up {
+			status = "up"
+		} else if scraped {
+			status = "down"
+		}
+		targets = append(targets, landingTarget{Name: name, Status: status})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	var buf bytes.Buffer
+	err := landingTemplate.Execute(&buf, struct {
+		Version                     string
+		Config, Scrape, Quit, Pprof bool
+		Targets                     []landingTarget
+	}{exporterVersion(), p.config, p.scrape, p.quit, p.pprof, targets})
+	if err != nil {
+		log.Errorf("Failed rendering landing page: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// exporterVersion is the module version the binary was built from
+func exporterVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
@@ -0,0 +1,1060 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	"github.com/Fathi122/simple-prometheus-exporter/demoserver"
+	"github.com/Fathi122/simple-prometheus-exporter/remotewrite"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	httpServerUrl = "http://localhost:8080"
+	httpAddr      = ":8080"
+	promhttpAddr  = ":9000"
+	// shutdownTimeout bounds the graceful shutdown of the servers
+	shutdownTimeout = 5 * time.Second
+	// defaultMaxRedirects follows as many redirects as Go's default client
+	// sends requests
+	defaultMaxRedirects = 10
+)
+
+var (
+	appEnabled        = flag.Bool("app.enabled", true, "Start the demo HTTP server.")
+	singlePort        = flag.Bool("single-port", false, "Serve the demo endpoints and /metrics from one server on -web.listen-address.")
+	listenAddress     = flag.String("web.listen-address", promhttpAddr, "Address the metrics server listens on, empty disables it when pushing.")
+	pushGatewayURL    = flag.String("push.gateway-url", "", "Pushgateway URL the metrics are pushed to every -push.interval.")
+	pushInterval      = flag.Duration("push.interval", time.Minute, "Interval between pushes to the Pushgateway.")
+	pushJob           = flag.String("push.job", "simple_prometheus_exporter", "Job name of the pushed metrics.")
+	remoteWriteURL    = flag.String("remote-write.url", "", "Remote-write endpoint the metrics are sent to every -remote-write.interval.")
+	remoteWriteEvery  = flag.Duration("remote-write.interval", remotewrite.DefaultInterval, "Interval between remote writes.")
+	remoteWriteQueue  = flag.Int("remote-write.queue-size", remotewrite.DefaultQueueSize, "Batches kept waiting for the remote-write endpoint before new ones are dropped.")
+	rwBearerTokenFile = flag.String("remote-write.bearer-token-file", "", "File holding a bearer token for the remote-write endpoint.")
+	rwBasicAuthUser   = flag.String("remote-write.basic-auth-user", "", "Basic auth user for the remote-write endpoint.")
+	rwBasicAuthPass   = flag.String("remote-write.basic-auth-password-file", "", "File holding the basic auth password for the remote-write endpoint.")
+	rwCAFile          = flag.String("remote-write.ca-file", "", "CA certificate file used to verify the remote-write endpoint.")
+	rwCertFile        = flag.String("remote-write.cert-file", "", "Client certificate file for the remote-write endpoint.")
+	rwKeyFile         = flag.String("remote-write.key-file", "", "Client key file for the remote-write endpoint.")
+	rwInsecure        = flag.Bool("remote-write.insecure-skip-verify", false, "Do not verify the certificate of the remote-write endpoint.")
+	pushGrouping      = newStringsFlag("push.grouping", "Grouping label of the pushed metrics as name=value, may be repeated.")
+	graphiteAddress   = flag.String("graphite.address", "", "Carbon plaintext TCP address the metrics are sent to every -graphite.interval.")
+	graphiteInterval  = flag.Duration("graphite.interval", time.Minute, "Interval between sends to Graphite and StatsD.")
+	graphitePrefix    = flag.String("graphite.prefix", "", "Prefix of the Graphite and StatsD metric paths.")
+	graphiteLabels    = flag.String("graphite.labels", labelsAsPath, "How labels are flattened into Graphite and StatsD paths: path (name.label.value), values (name.value) or tags (name;label=value, Graphite only).")
+	statsdAddress     = flag.String("statsd.address", "", "StatsD UDP address the metrics are sent to as gauges every -graphite.interval.")
+	readHeaderTimeout = flag.Duration("web.read-header-timeout", 10*time.Second, "Maximum time to read the headers of a request to the servers.")
+	readTimeout       = flag.Duration("web.read-timeout", 30*time.Second, "Maximum time to read a whole request to the servers.")
+	writeTimeout      = flag.Duration("web.write-timeout", 60*time.Second, "Maximum time to write a response, which includes scraping the target.")
+	idleTimeout       = flag.Duration("web.idle-timeout", 120*time.Second, "How long idle keep-alive connections to the servers are kept open.")
+	maxHeaderBytes    = flag.Int("web.max-header-bytes", http.DefaultMaxHeaderBytes, "Maximum size of the request headers accepted by the servers.")
+	webConfigFile     = flag.String("web.config.file", "", "YAML file with TLS and basic auth settings of the metrics server, reloaded on SIGHUP.")
+	drainDelay        = flag.Duration("web.drain-delay", 0, "How long /-/ready reports 503 on shutdown before the servers stop accepting connections.")
+	readyNeedsTarget  = flag.Bool("ready.requires-target", false, "Report /-/ready only after a successful scrape of the target.")
+	demoExpvar        = flag.Bool("demo.expvar", false, "Serve the demo counters and Go memstats on /debug/vars of the demo server.")
+	appRateLimit      = flag.Float64("app.rate-limit", 0, "Requests per second allowed on the demo endpoints, 0 disables limiting.")
+	targetURL         = flag.String("target.url", httpServerUrl, "Base URL of the server whose /stats endpoint is exported.")
+	noRuntimeMetrics  = flag.Bool("web.disable-runtime-metrics", false, "Do not expose go_* and process_* metrics, overrides -metrics.go-collector and -metrics.process-collector.")
+	goCollector       = flag.Bool("metrics.go-collector", true, "Expose the go_* metrics of the exporter.")
+	processCollector  = flag.Bool("metrics.process-collector", true, "Expose the process_* metrics of the exporter.")
+	enablePprof       = flag.Bool("web.enable-pprof", false, "Expose /debug/pprof/ endpoints on the metrics server.")
+	maxRequests       = flag.Int("web.max-requests", 0, "Maximum number of concurrent scrapes, further ones are answered with 503. 0 disables the limit.")
+	scrapeTimeout     = flag.Duration("web.scrape-timeout", 0, "Answer scrapes taking longer than this with 503, 0 disables the timeout.")
+	openMetrics       = flag.Bool("web.enable-openmetrics", false, "Serve /metrics in the OpenMetrics format to clients asking for it.")
+	logScrapesFlag    = flag.Bool("web.log-scrapes", false, "Log every request to /metrics with the client address, status and duration.")
+	enableScrape      = flag.Bool("web.enable-scrape-endpoint", false, "Fetch the target on GET /-/scrape and answer with the decoded stats or errors as JSON.")
+	exposeConfig      = flag.Bool("web.expose-config", false, "Serve the effective configuration as JSON on /-/config, with credentials redacted.")
+	enableLifecycle   = flag.Bool("web.enable-lifecycle", false, "Expose POST /-/quit on the metrics server to shut the exporter down.")
+	pprofAllowFrom    = newStringsFlag("web.pprof-allow-from", "Network in CIDR notation or address allowed to reach /debug/pprof/, may be repeated. All clients are allowed when unset.")
+	targetStrictJSON  = flag.Bool("target.strict-json", false, "Fail the scrape when the target's stats JSON contains unknown fields.")
+	targetRequireJSON = flag.Bool("target.require-json", false, "Fail the scrape when the target does not answer with an application/json Content-Type.")
+	startupJitter     = flag.Duration("target.startup-jitter", 0, "Maximum random delay before the collector starts scraping the target.")
+	metricOnNull      = flag.String("metric.on-null", "zero", "Default handling of null target values: skip, zero or nan.")
+	targetMethod      = flag.String("target.method", http.MethodGet, "HTTP method of the stats requests to the target.")
+	targetBody        = flag.String("target.body", "", "Body sent with the stats requests to the target, needs a -target.method other than GET.")
+	targetContentType = flag.String("target.content-type", "application/json", "Content-Type of -target.body.")
+	targetUserAgent   = flag.String("target.user-agent", "", "User-Agent sent with requests to the target.")
+	bearerTokenFile   = flag.String("target.bearer-token-file", "", "File holding a bearer token for the target, re-read on every scrape.")
+	basicAuthUser     = flag.String("target.basic-auth-user", "", "Basic auth user for the target.")
+	basicAuthPassFile = flag.String("target.basic-auth-password-file", "", "File holding the basic auth password for the target, re-read on every scrape.")
+	targetNoGzip      = flag.Bool("target.disable-gzip", false, "Do not request gzip compressed responses from the target.")
+	targetCAFile      = flag.String("target.ca-file", "", "CA certificate file used to verify the target.")
+	targetCertFile    = flag.String("target.cert-file", "", "Client certificate file for mutual TLS with the target.")
+	targetKeyFile     = flag.String("target.key-file", "", "Client key file for mutual TLS with the target.")
+	targetServerName  = flag.String("target.server-name", "", "Server name used for SNI and verification of the target certificate.")
+	targetInsecure    = flag.Bool("target.insecure-skip-verify", false, "Do not verify the target certificate.")
+	targetUnixSocket  = flag.String("target.unix-socket", "", "Unix socket to connect to instead of the target URL host.")
+	maxRedirects      = flag.Int("target.max-redirects", defaultMaxRedirects, "Maximum number of redirects followed for a target request, 0 fails the scrape on any redirect.")
+	crossHostRedirect = flag.Bool("target.allow-cross-host-redirects", false, "Follow redirects to hosts other than the target.")
+	maxIdleConns      = flag.Int("target.max-idle-conns", 100, "Maximum idle connections kept across all target hosts, 0 means no limit.")
+	maxIdleConnsHost  = flag.Int("target.max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "Maximum idle connections kept per target host.")
+	idleConnTimeout   = flag.Duration("target.idle-conn-timeout", 90*time.Second, "How long an idle target connection is kept open.")
+	disableKeepAlives = flag.Bool("target.disable-keepalives", false, "Open a new connection for every target request.")
+	forceHTTP2        = flag.Bool("target.force-attempt-http2", true, "Attempt HTTP/2 with TLS targets.")
+	connectionMaxAge  = flag.Duration("target.connection-max-age", 0, "Close idle target connections at this interval so DNS changes are picked up, 0 disables.")
+	dnsCacheTTL       = flag.Duration("target.dns-cache-ttl", 0, "Reuse the resolved addresses of the target host for this long, 0 disables caching.")
+	targetHTTP2       = flag.Bool("target.http2", false, "Use HTTP/2 for the target, as h2c for plain http targets.")
+	targetProxyURL    = flag.String("target.proxy-url", "", "Proxy URL for target requests, overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY.")
+	failureThreshold  = flag.Int("target.failure-threshold", 0, "Consecutive failed scrapes after which fetches of the target are suspended, 0 disables.")
+	failureCooldown   = flag.Duration("target.failure-cooldown", 30*time.Second, "How long fetches stay suspended once the failure threshold is reached.")
+	statsField200     = flag.String("stats.field-200", collector.DefaultField200, "Stats JSON key holding the count of 200 responses.")
+	statsField500     = flag.String("stats.field-500", collector.DefaultField500, "Stats JSON key holding the count of 500 responses.")
+	metricInclude     = newStringsFlag("metric.include", "Only expose the metric with this name, may be repeated. All metrics are exposed when unset.")
+	emitRates         = flag.Bool("metric.emit-rates", false, "Expose a <name>_per_second gauge with the rate between the last two scrapes of every counter.")
+	staleOnFailure    = flag.Bool("metric.stale-on-failure", true, "Leave the metrics of a failed fetch out so Prometheus marks them stale, false reports the last values read again.")
+	metricsConfigFile = flag.String("metrics.config", "", "YAML file mapping stats fields of one or more target paths to metrics, replaces the default metrics.")
+	statsFileMaxAge   = flag.Duration("target.file-max-age", 0, "Fail scrapes of a file:// target whose file was not modified for this long, 0 disables.")
+	targetTimeout     = flag.Duration("target.timeout", 0, "Maximum time of a request to a target including reading the response, 0 disables the timeout.")
+	maxBodyBytes      = flag.Int64("target.max-body-bytes", collector.DefaultMaxBodyBytes, "Maximum size of a target response, also after decompression.")
+	checkOnStart      = flag.Bool("target.check-on-start", false, "Fetch the target once at startup and warn when it fails.")
+	failOnStart       = flag.Bool("target.fail-on-start", false, "Exit when the startup fetch of the target fails, implies -target.check-on-start.")
+	upRequiresAllPath = flag.Bool("target.up-requires-all-paths", false, "Report the target down when any of its paths fails instead of only when all fail.")
+	instanceID        = flag.String("instance.id", "", "Value of an instance_id label on up and the other own metrics of the exporter, to tell exporters of the same target apart.")
+	traceparent       = flag.Bool("target.traceparent", false, "Send a W3C traceparent with target requests and expose its trace ID as exemplar of the request metrics with -web.enable-openmetrics.")
+	upReachable       = flag.Bool("target.up-means-reachable", false, "Report the target up when it answers at all, exposing bad statuses in httpserver_target_unhealthy.")
+	maxConcurrency    = flag.Int("target.max-concurrency", 10, "Maximum discovered targets fetched at once during a scrape, the others wait for a free slot.")
+	discoveryFile     = flag.String("discovery.file", "", "JSON or YAML file in the Prometheus file_sd format listing further targets as host:port, labelled with their group's labels and instance.")
+	discoveryRefresh  = flag.Duration("discovery.refresh-interval", 5*time.Minute, "Interval between re-reads of -discovery.file on top of watching it for changes.")
+	dnsSRVName        = flag.String("discovery.dns-srv-name", "", "SRV record such as _stats._tcp.app.internal whose targets are exported, labelled with their instance.")
+	dnsRefresh        = flag.Duration("discovery.dns-refresh-interval", 30*time.Second, "Interval between lookups of -discovery.dns-srv-name.")
+	dnsScheme         = flag.String("discovery.dns-scheme", "http", "Scheme of the targets of -discovery.dns-srv-name.")
+	dnsPath           = flag.String("discovery.dns-path", "", "Path prefix of the stats paths of the targets of -discovery.dns-srv-name.")
+	k8sSelector       = flag.String("discovery.kubernetes-selector", "", "Label selector of the EndpointSlices in the exporter's namespace whose ready endpoints are exported, labelled with pod, namespace and node. Needs a build with -tags kubernetes.")
+	k8sPort           = flag.String("discovery.kubernetes-port", "", "Name or number of the EndpointSlice port of the targets of -discovery.kubernetes-selector, the first port when empty.")
+	once              = flag.Bool("once", false, "Scrape the targets once without starting any server, print their metrics and exit 1 unless -once.min-up-ratio of them were up.")
+	onceMinUpRatio    = flag.Float64("once.min-up-ratio", 1, "Share of the targets that must be up for -once to succeed.")
+	serviceAction     = flag.String("service", "", "Control the Windows service of the exporter and exit: install registers it with the other flags given, uninstall, start or stop.")
+	logging           = newLogFlags(flag.CommandLine)
+	targetHeaders     = newHeaderFlag("target.header", "Header sent with requests to the target as Name=Value, may be repeated. Host overrides the request host.")
+)
+
+func init() {
+	// names used by other exporters for the client certificate
+	flag.StringVar(targetCertFile, "target.client-cert-file", "", "Alias of -target.cert-file.")
+	flag.StringVar(targetKeyFile, "target.client-key-file", "", "Alias of -target.key-file.")
+	flag.StringVar(discoveryFile, "target.discovery-file", "", "Alias of -discovery.file.")
+}
+
+// headerFlag collects repeated Name=Value flags into a header set
+type headerFlag http.Header
+
+// newHeaderFlag
+func newHeaderFlag(name, usage string) http.Header {
+	h := headerFlag{}
+	flag.Var(h, name, usage)
+	return http.Header(h)
+}
+
+// String lists the header names only since values may hold secrets
+func (h headerFlag) String() string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Set
+func (h headerFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("header %q is not of the form Name=Value", s)
+	}
+	if !collector.ValidHeaderName(parts[0]) {
+		return fmt.Errorf("invalid header name %q", parts[0])
+	}
+	http.Header(h).Add(parts[0], parts[1])
+	return nil
+}
+
+// stringsFlag collects the values of a repeated flag
+type stringsFlag []string
+
+// newStringsFlag
+func newStringsFlag(name, usage string) *[]string {
+	s := &stringsFlag{}
+	flag.Var(s, name, usage)
+	return (*[]string)(s)
+}
+
+// String
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// quitHandler starts the graceful shutdown on POST, after answering
+func quitHandler(quit context.CancelFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Info("Shutdown requested on /-/quit")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, "Shutting down")
+		// the servers wait for this response before closing
+		quit()
+	}
+}
+
+// metricsRouter
+func metricsRouter(cfg Config, registry *prometheus.Registry, exporter *collector.Collector, ready *readiness, landing *landingPage, quit context.CancelFunc) (*http.ServeMux, error) {
+	m := http.NewServeMux()
+	m.Handle("/", landing)
+	var metricsHandler http.Handler = promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		// counts encoding errors in promhttp_metric_handler_errors_total
+		Registry:            registry,
+		MaxRequestsInFlight: cfg.MaxRequestsInFlight,
+		Timeout:             cfg.ScrapeTimeout,
+		EnableOpenMetrics:   cfg.EnableOpenMetrics,
+		// serves the other metrics when one fails, such as after a recovered panic
+		ErrorHandling: promhttp.ContinueOnError,
+		ErrorLog:      log.StandardLogger(),
+	}))
+	metricsHandler = instrumentHandler(registry, metricsHandler)
+	if cfg.LogScrapes {
+		metricsHandler = logScrapes(metricsHandler)
+	}
+	m.Handle("/metrics", metricsHandler)
+	m.HandleFunc("/metrics.json", jsonMetricsHandler(registry))
+	m.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, "OK")
+	})
+	m.Handle("/-/ready", ready)
+	if cfg.EnableLifecycle {
+		m.HandleFunc("/-/quit", quitHandler(quit))
+	}
+	if cfg.ExposeConfig {
+		m.HandleFunc("/-/config", configHandler(cfg))
+	}
+	if cfg.EnableScrapeEndpoint {
+		m.HandleFunc("/-/scrape", scrapeHandler(exporter))
+	}
+	if cfg.EnablePprof {
+		if err := registerPprof(m, cfg.PprofAllowFrom); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Config holds the settings of an exporter instance
+type Config struct {
+	// AppEnabled starts the demo HTTP server
+	AppEnabled bool
+	// AppRateLimit limits the demo endpoints to this many requests per second, 0 disables
+	AppRateLimit float64
+	// DemoExpvar serves /debug/vars on the demo server
+	DemoExpvar bool
+	// HTTPAddr is the listen address of the demo HTTP server
+	HTTPAddr string
+	// MetricsAddr is the listen address of the metrics server, empty disables it
+	MetricsAddr string
+	// PushGatewayURL receives the metrics every PushInterval as PushJob,
+	// grouped by the name=value pairs of PushGrouping
+	PushGatewayURL string
+	PushInterval   time.Duration
+	PushJob        string
+	PushGrouping   []string
+	// RemoteWriteURL receives the metrics every RemoteWriteInterval, with
+	// the credentials and TLS settings below
+	RemoteWriteURL                   string
+	RemoteWriteInterval              time.Duration
+	RemoteWriteQueueSize             int
+	RemoteWriteBearerTokenFile       string
+	RemoteWriteBasicAuthUser         string
+	RemoteWriteBasicAuthPasswordFile string
+	RemoteWriteCAFile                string
+	RemoteWriteCertFile              string
+	RemoteWriteKeyFile               string
+	RemoteWriteInsecureSkipVerify    bool
+	// GraphiteAddress and StatsdAddress receive the metrics every
+	// GraphiteInterval, their paths prefixed with GraphitePrefix and labels
+	// flattened as GraphiteLabels
+	GraphiteAddress  string
+	StatsdAddress    string
+	GraphiteInterval time.Duration
+	GraphitePrefix   string
+	GraphiteLabels   string
+	// timeouts and header limit of the demo and metrics servers
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// WebConfigFile holds TLS and basic auth settings of the metrics server
+	WebConfigFile string
+	// DrainDelay keeps serving with /-/ready failing for this long on shutdown
+	DrainDelay time.Duration
+	// ReadyRequiresTarget holds back readiness until the target was scraped successfully
+	ReadyRequiresTarget bool
+	// SinglePort serves the demo endpoints from the metrics server, without the demo's /metrics
+	SinglePort bool
+	// TargetURL is the base URL of the server whose /stats are exported
+	TargetURL   string
+	EnablePprof bool
+	// PprofAllowFrom limits /debug/pprof/ to these networks or addresses when not empty
+	PprofAllowFrom []string
+	// MaxRequestsInFlight and ScrapeTimeout answer scrapes beyond them with 503, 0 disables
+	MaxRequestsInFlight int
+	ScrapeTimeout       time.Duration
+	// EnableOpenMetrics negotiates the OpenMetrics format on /metrics
+	EnableOpenMetrics bool
+	// LogScrapes logs every request to /metrics
+	LogScrapes bool
+	// ExposeConfig serves this configuration on /-/config
+	ExposeConfig bool
+	// EnableScrapeEndpoint fetches the target on demand on /-/scrape
+	EnableScrapeEndpoint bool
+	// EnableLifecycle exposes POST /-/quit
+	EnableLifecycle bool
+	// DisableRuntimeMetrics drops the go_* and process_* metrics, the other
+	// two settings drop either
+	DisableRuntimeMetrics   bool
+	DisableGoCollector      bool
+	DisableProcessCollector bool
+	StrictJSON              bool
+	RequireJSON             bool
+	// OnNull is the default null policy: skip, zero or nan
+	OnNull string
+	// TargetMethod, TargetBody and TargetContentType make the stats request
+	TargetMethod      string
+	TargetBody        string
+	TargetContentType string
+	// Headers are added to target requests, a Host entry overrides the request host
+	Headers   http.Header
+	UserAgent string
+	// FailureThreshold consecutive failures suspend fetches for FailureCooldown, 0 disables
+	FailureThreshold int
+	FailureCooldown  time.Duration
+	// StatsField200 and StatsField500 name the stats JSON keys of the request counters
+	StatsField200 string
+	StatsField500 string
+	// MetricsConfigFile maps stats fields of the target's paths to metrics instead of the defaults
+	MetricsConfigFile string
+	// UpRequiresAllPaths reports the target down when any path fails
+	UpRequiresAllPaths bool
+	// InstanceID labels the collector's own metrics when set
+	InstanceID string
+	// Traceparent traces target requests, with their trace IDs as exemplars
+	Traceparent bool
+	// UpMeansReachable reports the target up when it answers, even with a bad status
+	UpMeansReachable bool
+	// MaxBodyBytes limits target responses, stats files and command output, 0 uses the default
+	MaxBodyBytes int64
+	// FileMaxAge fails scrapes of file:// targets not modified for this long, 0 disables
+	FileMaxAge time.Duration
+	// TargetTimeout bounds each request to a target, 0 disables
+	TargetTimeout time.Duration
+	// EmitRates exposes per-second gauges of the counters
+	EmitRates bool
+	// StaleOnFailure leaves the metrics of failed fetches out instead of
+	// reporting the last values again
+	StaleOnFailure bool
+	// MetricInclude limits the exposed metrics to these names when not empty
+	MetricInclude []string
+	// DisableGzip stops requesting gzip compressed responses from the target
+	DisableGzip bool
+	// BearerTokenFile and the basic auth settings authenticate target requests
+	BearerTokenFile       string
+	BasicAuthUser         string
+	BasicAuthPasswordFile string
+	// UnixSocket is dialed instead of the target host, also set by unix:///path.sock:/stats target URLs
+	UnixSocket string
+	// MaxRedirects followed for a target request, 0 refuses all redirects
+	MaxRedirects int
+	// AllowCrossHostRedirects follows redirects to other hosts than the target
+	AllowCrossHostRedirects bool
+	// connection handling of the target transport
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	DisableKeepAlives   bool
+	ForceAttemptHTTP2   bool
+	// ConnectionMaxAge closes idle target connections at this interval, 0 disables
+	ConnectionMaxAge time.Duration
+	// DNSCacheTTL reuses resolved target addresses for this long, 0 resolves on every dial
+	DNSCacheTTL time.Duration
+	// HTTP2 talks HTTP/2 to the target, without TLS (h2c) for plain http URLs
+	HTTP2 bool
+	// ProxyURL overrides the proxy taken from the environment
+	ProxyURL string
+	// TLS settings for HTTPS targets, the files are reloaded on SIGHUP
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+	// CheckOnStart fetches the target once after the servers started, FailOnStart
+	// exits when that fails
+	CheckOnStart bool
+	FailOnStart  bool
+	// MaxConcurrency bounds the discovered targets collected at once
+	MaxConcurrency int
+	// DiscoveryFile lists further targets in the file_sd format, re-read on
+	// changes and every DiscoveryRefreshInterval
+	DiscoveryFile            string
+	DiscoveryRefreshInterval time.Duration
+	// DiscoverySRVName is resolved every DiscoverySRVRefreshInterval for
+	// further targets, scraped over DiscoverySRVScheme below DiscoverySRVPath
+	DiscoverySRVName            string
+	DiscoverySRVRefreshInterval time.Duration
+	DiscoverySRVScheme          string
+	DiscoverySRVPath            string
+	// KubernetesSelector selects the EndpointSlices of the pod's namespace
+	// whose ready endpoints on KubernetesPort are further targets
+	KubernetesSelector string
+	KubernetesPort     string
+	// StartupJitter is the upper bound of a random delay before the collector is registered
+	StartupJitter time.Duration
+}
+
+// newServer returns a server for handler with the configured timeouts
+func newServer(cfg Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// instance is an exporter set up from a Config: the collectors of the
+// target and of the discovered targets on a registry, without any server
+// or sender
+type instance struct {
+	target     *url.URL
+	targetTLS  *targetTLS
+	web        *webConfig
+	httpClient *http.Client
+	exporter   *collector.Collector
+	registry   *prometheus.Registry
+	// discoveries keep the collectors of the discovered targets
+	discoveries []*discovery
+}
+
+// targets returns the collectors of the target and of the discovered
+// targets, by redacted URL and by key
+func (inst *instance) targets() map[string]*collector.Collector {
+	targets := map[string]*collector.Collector{redactURL(inst.target.String()): inst.exporter}
+	for _, d := range inst.discoveries {
+		for key, c := range d.targets() {
+			targets[key] = c
+		}
+	}
+	return targets
+}
+
+// validate checks the settings that do not need any file or the network
+func (cfg Config) validate() error {
+	switch {
+	case cfg.MetricsAddr == "" && cfg.PushGatewayURL == "" && cfg.RemoteWriteURL == "" && cfg.GraphiteAddress == "" && cfg.StatsdAddress == "":
+		return errors.New("no listen address for the metrics server and no Pushgateway, remote-write, Graphite or StatsD endpoint to send to")
+	case cfg.MetricsAddr == "" && cfg.SinglePort:
+		return errors.New("single port mode needs a listen address for the metrics server")
+	case cfg.PushGatewayURL != "" && cfg.PushInterval <= 0:
+		return errors.New("push interval must be positive")
+	case (cfg.GraphiteAddress != "" || cfg.StatsdAddress != "") && cfg.GraphiteInterval <= 0:
+		return errors.New("graphite interval must be positive")
+	case cfg.MaxConcurrency <= 0:
+		return errors.New("maximum target concurrency must be positive")
+	case cfg.DiscoveryFile != "" && cfg.DiscoveryRefreshInterval <= 0:
+		return errors.New("discovery refresh interval must be positive")
+	case cfg.DiscoverySRVName != "" && cfg.DiscoverySRVRefreshInterval <= 0:
+		return errors.New("DNS discovery refresh interval must be positive")
+	case cfg.DiscoverySRVName != "" && cfg.DiscoverySRVScheme != "http" && cfg.DiscoverySRVScheme != "https":
+		return fmt.Errorf("DNS discovery scheme %q is not http or https", cfg.DiscoverySRVScheme)
+	case cfg.DiscoverySRVPath != "" && !strings.HasPrefix(cfg.DiscoverySRVPath, "/"):
+		return fmt.Errorf("DNS discovery path %q does not start with /", cfg.DiscoverySRVPath)
+	}
+	return nil
+}
+
+// newInstance sets up the exporter of cfg, with the collector of the target
+// not registered yet. Discovered targets are refreshed until ctx is done.
+func newInstance(ctx context.Context, cfg Config) (*instance, error) {
+	startTime := time.Now()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	httpServerURL, err := url.Parse(cfg.TargetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target url: %w", err)
+	}
+	if httpServerURL.Scheme == "unix" {
+		cfg.UnixSocket, httpServerURL = splitUnixTarget(httpServerURL)
+	} else if cfg.UnixSocket != "" {
+		// the host is only a placeholder when dialing a socket
+		httpServerURL.Host = "unix"
+	}
+	// register prometheus exporter
+	targetTLS, err := newTargetTLS(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var web *webConfig
+	if cfg.WebConfigFile != "" {
+		if web, err = loadWebConfig(cfg.WebConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	transport, err := newTargetTransport(cfg, httpServerURL)
+	if err != nil {
+		return nil, err
+	}
+	var roundTripper http.RoundTripper = transport
+	if cfg.HTTP2 {
+		roundTripper = enableHTTP2(transport, httpServerURL)
+	}
+	if transport, ok := roundTripper.(*http.Transport); ok && targetTLS.enabled() {
+		roundTripper = targetTLS.wrap(transport)
+	}
+	httpClient := &http.Client{
+		Transport:     roundTripper,
+		CheckRedirect: redirectPolicy(cfg.MaxRedirects, cfg.AllowCrossHostRedirects),
+	}
+	mapping := collector.DefaultMapping(cfg.StatsField200, cfg.StatsField500)
+	if cfg.MetricsConfigFile != "" {
+		mapping, err = collector.LoadMapping(cfg.MetricsConfigFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts := []collector.Option{
+		collector.WithHTTPClient(httpClient),
+		collector.WithMapping(mapping),
+		collector.WithStrictJSON(cfg.StrictJSON),
+		collector.WithRequireJSON(cfg.RequireJSON),
+		collector.WithOnNull(cfg.OnNull),
+		collector.WithHeaders(cfg.Headers),
+		collector.WithUserAgent(cfg.UserAgent),
+		collector.WithDisableGzip(cfg.DisableGzip),
+		collector.WithCircuitBreaker(cfg.FailureThreshold, cfg.FailureCooldown),
+		collector.WithInclude(cfg.MetricInclude...),
+		collector.WithRates(cfg.EmitRates),
+		collector.WithStaleOnFailure(cfg.StaleOnFailure),
+		collector.WithBearerTokenFile(cfg.BearerTokenFile),
+		collector.WithBasicAuth(cfg.BasicAuthUser, cfg.BasicAuthPasswordFile),
+		collector.WithMaxBodyBytes(cfg.MaxBodyBytes),
+		collector.WithUpRequiresAllPaths(cfg.UpRequiresAllPaths),
+		collector.WithUpMeansReachable(cfg.UpMeansReachable),
+		collector.WithTraceparent(cfg.Traceparent),
+		collector.WithInstanceID(cfg.InstanceID),
+		collector.WithStatsRequest(cfg.TargetMethod, []byte(cfg.TargetBody), cfg.TargetContentType),
+		collector.WithFileMaxAge(cfg.FileMaxAge),
+		collector.WithStartTime(startTime),
+		collector.WithLogger(log.StandardLogger()),
+	}
+	if cfg.TargetTimeout > 0 {
+		opts = append(opts, collector.WithTimeout(cfg.TargetTimeout))
+	}
+	exporter, err := collector.NewCollector(httpServerURL.String(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	registry := prometheus.NewRegistry()
+	if !cfg.DisableRuntimeMetrics && !cfg.DisableGoCollector {
+		registry.MustRegister(collectors.NewGoCollector())
+	}
+	if !cfg.DisableRuntimeMetrics && !cfg.DisableProcessCollector {
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	var discoveries []*discovery
+	// shared by the discoveries so the limit holds across all targets
+	slots := make(chan struct{}, cfg.MaxConcurrency)
+	newDiscoveredCollector := func(target string, labels prometheus.Labels) (*collector.Collector, error) {
+		return collector.NewCollector(target, append(opts[:len(opts):len(opts)], collector.WithConstLabels(labels))...)
+	}
+	if cfg.DiscoveryFile != "" {
+		file := cfg.DiscoveryFile
+		d, err := newDiscovery("file", func() (map[string]discoveredTarget, error) { return readDiscoveryFile(file) }, registry, slots, newDiscoveredCollector)
+		if err != nil {
+			return nil, err
+		}
+		// a file that cannot be read is a configuration error at startup
+		if err := d.refresh(); err != nil {
+			return nil, err
+		}
+		discoveries = append(discoveries, d)
+		changed, err := watchFile(ctx, file)
+		if err != nil {
+			log.Warnf("Failed watching discovery file %s, re-reading it every %s only: %v", file, cfg.DiscoveryRefreshInterval, err)
+		}
+		go d.run(ctx, cfg.DiscoveryRefreshInterval, changed)
+	}
+	if cfg.DiscoverySRVName != "" {
+		source := srvTargets(net.DefaultResolver, cfg.DiscoverySRVName, cfg.DiscoverySRVScheme, cfg.DiscoverySRVPath)
+		d, err := newDiscovery("dns", source, registry, slots, newDiscoveredCollector)
+		if err != nil {
+			return nil, err
+		}
+		discoveries = append(discoveries, d)
+		// DNS may not be ready yet, the next refresh tries again
+		if err := d.refresh(); err != nil {
+			log.Errorf("Failed resolving %s, retrying in %s: %v", cfg.DiscoverySRVName, cfg.DiscoverySRVRefreshInterval, err)
+		}
+		go d.run(ctx, cfg.DiscoverySRVRefreshInterval, nil)
+	}
+	if cfg.KubernetesSelector != "" {
+		source, changed, err := kubernetesTargets(ctx, cfg.KubernetesSelector, cfg.KubernetesPort)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newDiscovery("kubernetes", source, registry, slots, newDiscoveredCollector)
+		if err != nil {
+			return nil, err
+		}
+		discoveries = append(discoveries, d)
+		if err := d.refresh(); err != nil {
+			log.Errorf("Failed listing EndpointSlices, retrying on the next change: %v", err)
+		}
+		go d.run(ctx, kubernetesResync, changed)
+	}
+	return &instance{
+		target:      httpServerURL,
+		targetTLS:   targetTLS,
+		web:         web,
+		httpClient:  httpClient,
+		exporter:    exporter,
+		registry:    registry,
+		discoveries: discoveries,
+	}, nil
+}
+
+// Run starts the demo and metrics servers and blocks until ctx is cancelled
+// or a server fails, then shuts both servers down gracefully
+func Run(ctx context.Context, cfg Config) error {
+	// quit shuts down like a cancelled ctx, for /-/quit
+	ctx, quit := context.WithCancel(ctx)
+	defer quit()
+	inst, err := newInstance(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	httpServerURL, targetTLS, web, exporter, registry := inst.target, inst.targetTLS, inst.web, inst.exporter, inst.registry
+	if cfg.ConnectionMaxAge > 0 {
+		// dropping idle connections makes the next scrape resolve the target again
+		go func() {
+			ticker := time.NewTicker(cfg.ConnectionMaxAge)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					inst.httpClient.CloseIdleConnections()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	if targetTLS.enabled() || web != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for {
+				select {
+				case <-hup:
+					if web != nil {
+						if err := web.reload(); err != nil {
+							log.Errorf("Failed reloading web config: %v", err)
+						} else {
+							log.Info("Reloaded web config")
+						}
+					}
+					if !targetTLS.enabled() {
+						continue
+					}
+					if err := targetTLS.reload(); err != nil {
+						log.Errorf("Failed reloading target TLS files: %v", err)
+						continue
+					}
+					log.Info("Reloaded target TLS files")
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	log.Infof("Exporting stats of target '%s'", httpServerURL.Redacted())
+	errs := make(chan error, 4)
+	var metricsPusher *pusher
+	if cfg.PushGatewayURL != "" {
+		if metricsPusher, err = newPusher(cfg.PushGatewayURL, cfg.PushJob, cfg.PushGrouping, registry); err != nil {
+			return err
+		}
+	}
+	var sender *remotewrite.Sender
+	if cfg.RemoteWriteURL != "" {
+		sender, err = remotewrite.NewSender(cfg.RemoteWriteURL, registry,
+			remotewrite.WithInterval(cfg.RemoteWriteInterval),
+			remotewrite.WithQueueSize(cfg.RemoteWriteQueueSize),
+			remotewrite.WithBearerTokenFile(cfg.RemoteWriteBearerTokenFile),
+			remotewrite.WithBasicAuth(cfg.RemoteWriteBasicAuthUser, cfg.RemoteWriteBasicAuthPasswordFile),
+			remotewrite.WithTLSFiles(cfg.RemoteWriteCAFile, cfg.RemoteWriteCertFile, cfg.RemoteWriteKeyFile, cfg.RemoteWriteInsecureSkipVerify),
+			remotewrite.WithLogger(log.StandardLogger()),
+		)
+		if err != nil {
+			return err
+		}
+		if err := registry.Register(sender); err != nil {
+			return err
+		}
+	}
+	var bridges []*bridge
+	if cfg.GraphiteAddress != "" {
+		b, err := newGraphiteBridge(cfg.GraphiteAddress, cfg.GraphitePrefix, cfg.GraphiteLabels, registry)
+		if err != nil {
+			return err
+		}
+		bridges = append(bridges, b)
+	}
+	if cfg.StatsdAddress != "" {
+		b, err := newStatsdBridge(cfg.StatsdAddress, cfg.GraphitePrefix, cfg.GraphiteLabels, registry)
+		if err != nil {
+			return err
+		}
+		bridges = append(bridges, b)
+	}
+	ready := &readiness{requiresTarget: cfg.ReadyRequiresTarget, exporter: exporter}
+	if cfg.StartupJitter > 0 {
+		// delay registration so exporters started together do not stampede
+		// their target, without holding back the metrics server
+		delay := time.Duration(rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(int64(cfg.StartupJitter)))
+		log.Infof("Delaying collector registration by %s", delay)
+		jitterCtx, stopJitter := context.WithCancel(ctx)
+		defer stopJitter()
+		go func() {
+			select {
+			case <-time.After(delay):
+				if err := registry.Register(exporter); err != nil {
+					errs <- err
+					return
+				}
+				ready.setRegistered()
+			case <-jitterCtx.Done():
+			}
+		}()
+	} else {
+		if err := registry.Register(exporter); err != nil {
+			return err
+		}
+		ready.setRegistered()
+	}
+
+	var servers []*http.Server
+	activated, err := activatedListeners()
+	if err != nil {
+		return err
+	}
+	serve := func(s *http.Server) error {
+		// listen before returning so the start check finds the demo server
+		ln := activated.take(s.Addr)
+		if ln != nil {
+			log.Infof("Serving %s on the socket passed by systemd", s.Addr)
+		} else if ln, err = net.Listen("tcp", s.Addr); err != nil {
+			return err
+		}
+		servers = append(servers, s)
+		go func() {
+			serveFn := s.Serve
+			if s.TLSConfig != nil {
+				// plain HTTP requests are answered with 400 by ServeTLS
+				serveFn = func(ln net.Listener) error { return s.ServeTLS(ln, "", "") }
+			}
+			if err := serveFn(ln); err != http.ErrServerClosed {
+				errs <- err
+			}
+		}()
+		return nil
+	}
+	router, err := metricsRouter(cfg, registry, exporter, ready, newLandingPage(cfg, inst.targets), quit)
+	if err != nil {
+		return err
+	}
+	if cfg.EnablePprof {
+		log.Warn("pprof endpoints are enabled on /debug/pprof/, they expose profiles and the command line of the process")
+	}
+	if cfg.AppEnabled && cfg.SinglePort {
+		// the mux panics on a route registered twice
+		demoserver.Register(router, cfg.AppRateLimit, cfg.DemoExpvar)
+	} else if cfg.AppEnabled {
+		log.Infof("HttpServer listening on '%s'", cfg.HTTPAddr)
+		err = serve(newServer(cfg, cfg.HTTPAddr, demoserver.Handler(cfg.AppRateLimit, cfg.DemoExpvar)))
+	}
+	if err == nil && cfg.MetricsAddr != "" {
+		log.Infof("PromHttpServer listening on '%s'", cfg.MetricsAddr)
+		metricsServer := newServer(cfg, cfg.MetricsAddr, router)
+		if web != nil {
+			metricsServer.Handler = web.handler(router)
+			if web.tlsEnabled() {
+				metricsServer.TLSConfig = web.serverTLSConfig()
+			}
+		}
+		err = serve(metricsServer)
+	}
+	if unused := activated.unused(); err == nil && len(unused) > 0 {
+		log.Warnf("Sockets passed by systemd match no listen address: %s", strings.Join(unused, ", "))
+	}
+	if err == nil && (cfg.CheckOnStart || cfg.FailOnStart) {
+		if cerr := exporter.Check(ctx); cerr != nil {
+			log.Warnf("Startup check of target %s failed: %v", httpServerURL.Redacted(), cerr)
+			if cfg.FailOnStart {
+				err = fmt.Errorf("startup check of target failed: %w", cerr)
+			}
+		} else {
+			log.Infof("Startup check of target %s succeeded", httpServerURL.Redacted())
+		}
+	}
+
+	// senders of the metrics, stopped by ctx
+	var senders sync.WaitGroup
+	if err == nil && metricsPusher != nil {
+		log.Infof("Pushing metrics to %s every %s", cfg.PushGatewayURL, cfg.PushInterval)
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			metricsPusher.run(ctx, cfg.PushInterval)
+		}()
+	}
+	if err == nil && sender != nil {
+		// parsed before by NewSender
+		rwURL, _ := url.Parse(cfg.RemoteWriteURL)
+		log.Infof("Sending metrics to remote-write endpoint %s every %s", rwURL.Redacted(), cfg.RemoteWriteInterval)
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			sender.Run(ctx)
+		}()
+	}
+	for _, b := range bridges {
+		if err == nil {
+			log.Infof("Sending metrics to %s every %s", b.name, cfg.GraphiteInterval)
+			senders.Add(1)
+			go func(b *bridge) {
+				defer senders.Done()
+				b.run(ctx, cfg.GraphiteInterval)
+			}(b)
+		}
+	}
+
+	// on a startup error the servers already started are shut down right away
+	if err == nil {
+		sdNotify("READY=1")
+		go runWatchdog(ctx)
+		select {
+		case <-ctx.Done():
+		case err = <-errs:
+		}
+	}
+	sdNotify("STOPPING=1")
+
+	ready.shutdown()
+	// stops the pusher and the other goroutines on server errors too
+	quit()
+	if err == nil && cfg.DrainDelay > 0 {
+		log.Infof("Draining for %s before shutting down", cfg.DrainDelay)
+		time.Sleep(cfg.DrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	senders.Wait()
+	if err == nil && metricsPusher != nil {
+		// a last push so the Pushgateway holds the final values
+		if perr := metricsPusher.push(shutdownCtx); perr != nil {
+			log.Errorf("Failed final push of metrics: %v", perr)
+		}
+	}
+	for _, s := range servers {
+		if serr := s.Shutdown(shutdownCtx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// runUntilSignalled runs the exporter until SIGINT or SIGTERM
+func runUntilSignalled(cfg Config) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Info(sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return Run(ctx, cfg)
+}
+
+// flagConfig returns the Config of the parsed flags
+func flagConfig() Config {
+	target := *targetURL
+	if *singlePort && !flagSet("target.url") {
+		// the demo endpoints are served next to /metrics
+		if _, port, err := net.SplitHostPort(*listenAddress); err == nil {
+			target = "http://" + net.JoinHostPort("localhost", port)
+		}
+	}
+	return Config{
+		AppEnabled:                       *appEnabled,
+		AppRateLimit:                     *appRateLimit,
+		DemoExpvar:                       *demoExpvar,
+		HTTPAddr:                         httpAddr,
+		MetricsAddr:                      *listenAddress,
+		PushGatewayURL:                   *pushGatewayURL,
+		PushInterval:                     *pushInterval,
+		PushJob:                          *pushJob,
+		PushGrouping:                     *pushGrouping,
+		RemoteWriteURL:                   *remoteWriteURL,
+		RemoteWriteInterval:              *remoteWriteEvery,
+		RemoteWriteQueueSize:             *remoteWriteQueue,
+		RemoteWriteBearerTokenFile:       *rwBearerTokenFile,
+		RemoteWriteBasicAuthUser:         *rwBasicAuthUser,
+		RemoteWriteBasicAuthPasswordFile: *rwBasicAuthPass,
+		RemoteWriteCAFile:                *rwCAFile,
+		RemoteWriteCertFile:              *rwCertFile,
+		RemoteWriteKeyFile:               *rwKeyFile,
+		RemoteWriteInsecureSkipVerify:    *rwInsecure,
+		GraphiteAddress:                  *graphiteAddress,
+		StatsdAddress:                    *statsdAddress,
+		GraphiteInterval:                 *graphiteInterval,
+		GraphitePrefix:                   *graphitePrefix,
+		GraphiteLabels:                   *graphiteLabels,
+		SinglePort:                       *singlePort,
+		ReadHeaderTimeout:                *readHeaderTimeout,
+		ReadTimeout:                      *readTimeout,
+		WriteTimeout:                     *writeTimeout,
+		IdleTimeout:                      *idleTimeout,
+		MaxHeaderBytes:                   *maxHeaderBytes,
+		WebConfigFile:                    *webConfigFile,
+		DrainDelay:                       *drainDelay,
+		ReadyRequiresTarget:              *readyNeedsTarget,
+		CheckOnStart:                     *checkOnStart,
+		FailOnStart:                      *failOnStart,
+		TargetURL:                        target,
+		EnablePprof:                      *enablePprof,
+		PprofAllowFrom:                   *pprofAllowFrom,
+		EnableLifecycle:                  *enableLifecycle,
+		ExposeConfig:                     *exposeConfig,
+		EnableScrapeEndpoint:             *enableScrape,
+		LogScrapes:                       *logScrapesFlag,
+		MaxRequestsInFlight:              *maxRequests,
+		ScrapeTimeout:                    *scrapeTimeout,
+		EnableOpenMetrics:                *openMetrics,
+		DisableRuntimeMetrics:            *noRuntimeMetrics,
+		DisableGoCollector:               !*goCollector,
+		DisableProcessCollector:          !*processCollector,
+		StrictJSON:                       *targetStrictJSON,
+		RequireJSON:                      *targetRequireJSON,
+		OnNull:                           *metricOnNull,
+		StartupJitter:                    *startupJitter,
+		MaxConcurrency:                   *maxConcurrency,
+		DiscoveryFile:                    *discoveryFile,
+		DiscoveryRefreshInterval:         *discoveryRefresh,
+		DiscoverySRVName:                 *dnsSRVName,
+		DiscoverySRVRefreshInterval:      *dnsRefresh,
+		DiscoverySRVScheme:               *dnsScheme,
+		DiscoverySRVPath:                 *dnsPath,
+		KubernetesSelector:               *k8sSelector,
+		KubernetesPort:                   *k8sPort,
+		Headers:                          targetHeaders,
+		UserAgent:                        *targetUserAgent,
+		DisableGzip:                      *targetNoGzip,
+		MetricInclude:                    *metricInclude,
+		EmitRates:                        *emitRates,
+		StaleOnFailure:                   *staleOnFailure,
+		MetricsConfigFile:                *metricsConfigFile,
+		UpRequiresAllPaths:               *upRequiresAllPath,
+		UpMeansReachable:                 *upReachable,
+		Traceparent:                      *traceparent,
+		InstanceID:                       *instanceID,
+		TargetMethod:                     *targetMethod,
+		TargetBody:                       *targetBody,
+		TargetContentType:                *targetContentType,
+		FileMaxAge:                       *statsFileMaxAge,
+		MaxBodyBytes:                     *maxBodyBytes,
+		TargetTimeout:                    *targetTimeout,
+		StatsField200:                    *statsField200,
+		StatsField500:                    *statsField500,
+		FailureThreshold:                 *failureThreshold,
+		FailureCooldown:                  *failureCooldown,
+		BearerTokenFile:                  *bearerTokenFile,
+		BasicAuthUser:                    *basicAuthUser,
+		BasicAuthPasswordFile:            *basicAuthPassFile,
+		UnixSocket:                       *targetUnixSocket,
+		ProxyURL:                         *targetProxyURL,
+		MaxIdleConns:                     *maxIdleConns,
+		MaxIdleConnsPerHost:              *maxIdleConnsHost,
+		IdleConnTimeout:                  *idleConnTimeout,
+		DisableKeepAlives:                *disableKeepAlives,
+		ForceAttemptHTTP2:                *forceHTTP2,
+		HTTP2:                            *targetHTTP2,
+		ConnectionMaxAge:                 *connectionMaxAge,
+		DNSCacheTTL:                      *dnsCacheTTL,
+		MaxRedirects:                     *maxRedirects,
+		AllowCrossHostRedirects:          *crossHostRedirect,
+		TLSCAFile:                        *targetCAFile,
+		TLSCertFile:                      *targetCertFile,
+		TLSKeyFile:                       *targetKeyFile,
+		TLSServerName:                    *targetServerName,
+		TLSInsecureSkipVerify:            *targetInsecure,
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command of args and returns its exit code once the log file
+// is closed, which os.Exit would skip
+func run(args []string) int {
+	defer closeLogFile()
+	return runCommand(args)
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// freeAddr returns a loopback address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// testConfig returns the default configuration on free ports, exporting the
+// stats of a stub target
+func testConfig(t *testing.T) Config {
+	t.Helper()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+	}))
+	t.Cleanup(target.Close)
+	cfg := flagConfig()
+	cfg.TargetURL = target.URL
+	cfg.HTTPAddr = freeAddr(t)
+	cfg.MetricsAddr = freeAddr(t)
+	return cfg
+}
+
+// startRun runs the exporter with cfg until the test ends, once its metrics
+// server answers
+func startRun(t *testing.T, cfg Config) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	t.Cleanup(func() {
+		// a connection the client dialed but never used holds up the
+		// graceful shutdown for as long as its timeout
+		http.DefaultClient.CloseIdleConnections()
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, err := http.Get("http://" + cfg.MetricsAddr + "/metrics")
+		if err == nil {
+			response.Body.Close()
+			return
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("Run returned early: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics server not answering: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAppEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"enabled", true},
+		{"disabled", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.AppEnabled = tc.enabled
+			startRun(t, cfg)
+			conn, err := net.Dial("tcp", cfg.HTTPAddr)
+			if err == nil {
+				conn.Close()
+			}
+			if bound := err == nil; bound != tc.enabled {
+				t.Errorf("demo server address bound = %v, want %v", bound, tc.enabled)
+			}
+		})
+	}
+}
+
+func TestQuit(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AppEnabled = false
+	cfg.EnableLifecycle = true
+	done := make(chan error, 1)
+	go func() { done <- Run(context.Background(), cfg) }()
+	quitURL := "http://" + cfg.MetricsAddr + "/-/quit"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, err := http.Get(quitURL)
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode != http.StatusMethodNotAllowed || response.Header.Get("Allow") != http.MethodPost {
+				t.Fatalf("GET /-/quit: status %s, Allow %q", response.Status, response.Header.Get("Allow"))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics server not answering: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("GET /-/quit stopped the exporter: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	response, err := http.Post(quitURL, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("POST /-/quit: status %s", response.Status)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("POST /-/quit did not stop the exporter")
+	}
+}
+
+func TestQuitDisabled(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AppEnabled = false
+	startRun(t, cfg)
+	response, err := http.Post("http://"+cfg.MetricsAddr+"/-/quit", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("POST /-/quit without -web.enable-lifecycle: status %s, want 404", response.Status)
+	}
+}
+
+func TestRunLogsRedactPassword(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	cfg := testConfig(t)
+	cfg.AppEnabled = false
+	cfg.TargetURL = strings.Replace(cfg.TargetURL, "http://", "http://admin:s3cret@", 1)
+	cfg.CheckOnStart = true
+	t.Run("run", func(t *testing.T) {
+		startRun(t, cfg)
+	})
+	if !strings.Contains(logs.String(), "Exporting stats of target") {
+		t.Fatalf("startup not logged:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "s3cret") {
+		t.Errorf("logs leak the password:\n%s", logs.String())
+	}
+}
+
+// get requests url and returns the status code and body of the response
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(body)
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		disabled bool
+	}{
+		{"enabled", false},
+		{"disabled", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.AppEnabled = false
+			cfg.DisableRuntimeMetrics = tc.disabled
+			startRun(t, cfg)
+			_, body := get(t, "http://"+cfg.MetricsAddr+"/metrics")
+			if exposed := strings.Contains(body, "\ngo_goroutines "); exposed == tc.disabled {
+				t.Errorf("go_goroutines exposed = %v with -web.disable-runtime-metrics = %v", exposed, tc.disabled)
+			}
+			if !strings.Contains(body, "\nhttpserver_up ") {
+				t.Errorf("httpserver_up not exposed:\n%s", body)
+			}
+		})
+	}
+}
+
+// scrapeValue scrapes the exporter of cfg and returns the value of the
+// unlabelled metric name
+func scrapeValue(t *testing.T, cfg Config, name string) float64 {
+	t.Helper()
+	_, body := get(t, "http://"+cfg.MetricsAddr+"/metrics")
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	family, ok := families[name]
+	if !ok {
+		t.Fatalf("%s not exposed:\n%s", name, body)
+	}
+	m := family.GetMetric()[0]
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestConnectionMaxAge(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		maxAge time.Duration
+		// connections opened by two scrapes further apart than maxAge
+		connections int32
+	}{
+		{"reused", 0, 1},
+		{"refreshed", 20 * time.Millisecond, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var connections int32
+			target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+			}))
+			target.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					atomic.AddInt32(&connections, 1)
+				}
+			}
+			target.Start()
+			defer target.Close()
+			cfg := testConfig(t)
+			cfg.AppEnabled = false
+			// a host name, so reconnecting resolves it again
+			cfg.TargetURL = strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+			cfg.ConnectionMaxAge = tc.maxAge
+			startRun(t, cfg)
+			time.Sleep(100 * time.Millisecond)
+			lookups := scrapeValue(t, cfg, "exporter_target_dns_lookups_total")
+			if n := atomic.LoadInt32(&connections); n != tc.connections {
+				t.Errorf("%d connections to the target, want %d", n, tc.connections)
+			}
+			if lookups != float64(tc.connections) {
+				t.Errorf("exporter_target_dns_lookups_total = %v, want %d", lookups, tc.connections)
+			}
+		})
+	}
+}
+
+func TestHealthyAndReady(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		// ready is the /-/ready answer once /metrics was scraped
+		readyStatus int
+		ready       string
+	}{
+		{"target_up", http.StatusOK, http.StatusOK, "OK"},
+		{"target_down", http.StatusInternalServerError, http.StatusServiceUnavailable, "Not Ready"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+			}))
+			defer target.Close()
+			cfg := testConfig(t)
+			cfg.AppEnabled = false
+			cfg.TargetURL = target.URL
+			cfg.ReadyRequiresTarget = true
+			startRun(t, cfg)
+			if status, body := get(t, "http://"+cfg.MetricsAddr+"/-/healthy"); status != http.StatusOK || body != "OK" {
+				t.Errorf("/-/healthy: %d %q, want 200 OK", status, body)
+			}
+			if status, body := get(t, "http://"+cfg.MetricsAddr+"/-/ready"); status != tc.readyStatus || body != tc.ready {
+				t.Errorf("/-/ready: %d %q, want %d %q", status, body, tc.readyStatus, tc.ready)
+			}
+		})
+	}
+}
+
+func TestSinglePort(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SinglePort = true
+	startRun(t, cfg)
+	if conn, err := net.Dial("tcp", cfg.HTTPAddr); err == nil {
+		conn.Close()
+		t.Error("demo server address bound with -single-port")
+	}
+	addr := "http://" + cfg.MetricsAddr
+	if status, _ := get(t, addr+"/test200"); status != http.StatusOK {
+		t.Errorf("/test200 on the metrics address: status %d", status)
+	}
+	if status, body := get(t, addr+"/stats"); status != http.StatusOK || !strings.Contains(body, `"http200Requestcounter"`) {
+		t.Errorf("/stats on the metrics address: %d %s", status, body)
+	}
+	if status, body := get(t, addr+"/metrics"); status != http.StatusOK || !strings.Contains(body, "\nhttpserver_up ") {
+		t.Errorf("/metrics on the metrics address is not the exporter's: %d\n%s", status, body)
+	}
+}
+
+func TestCheckOnStart(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		unreachable bool
+	}{
+		{"success", false},
+		{"failure", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+			cfg := testConfig(t)
+			cfg.AppEnabled = false
+			cfg.CheckOnStart = true
+			if tc.unreachable {
+				cfg.TargetURL = "http://" + freeAddr(t)
+			}
+			t.Run("run", func(t *testing.T) {
+				startRun(t, cfg)
+			})
+			want := "Startup check of target " + cfg.TargetURL + " succeeded"
+			if tc.unreachable {
+				want = "Startup check of target " + cfg.TargetURL + " failed"
+			}
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("%q not logged:\n%s", want, logs.String())
+			}
+		})
+	}
+}
+
+func TestFailOnStart(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AppEnabled = false
+	cfg.FailOnStart = true
+	cfg.TargetURL = "http://" + freeAddr(t)
+	done := make(chan error, 1)
+	go func() { done <- Run(context.Background(), cfg) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "startup check of target failed") {
+			t.Errorf("Run: %v, want a failed startup check", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exporter kept running after a failed startup check")
+	}
+}
+
+func TestMetricsServerRoutes(t *testing.T) {
+	cfg := testConfig(t)
+	startRun(t, cfg)
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/", http.StatusOK},
+		{"/metrics", http.StatusOK},
+		{"/metrics.json", http.StatusOK},
+		{"/-/healthy", http.StatusOK},
+		{"/-/ready", http.StatusOK},
+		// registered on http.DefaultServeMux by imported packages
+		{"/debug/pprof/", http.StatusNotFound},
+		{"/debug/vars", http.StatusNotFound},
+		// served by the demo server or behind flags
+		{"/stats", http.StatusNotFound},
+		{"/test200", http.StatusNotFound},
+		{"/-/quit", http.StatusNotFound},
+		{"/-/config", http.StatusNotFound},
+		{"/-/scrape", http.StatusNotFound},
+		{"/unknown", http.StatusNotFound},
+	} {
+		if status, _ := get(t, "http://"+cfg.MetricsAddr+tc.path); status != tc.status {
+			t.Errorf("GET %s: status %d, want %d", tc.path, status, tc.status)
+		}
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AppEnabled = false
+	cfg.ReadHeaderTimeout = 50 * time.Millisecond
+	startRun(t, cfg)
+	conn, err := net.Dial("tcp", cfg.MetricsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a client that never finishes its headers
+	if _, err := conn.Write([]byte("GET /metrics HTTP/1.1\r\nHost: exporter\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection not closed after the read header timeout: %v", err)
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	const openMetricsAccept = "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5"
+	for _, tc := range []struct {
+		name        string
+		enabled     bool
+		accept      string
+		contentType string
+	}{
+		{"text", true, "text/plain; version=0.0.4", "text/plain; version=0.0.4"},
+		{"openmetrics", true, openMetricsAccept, "application/openmetrics-text"},
+		{"openmetrics_disabled", false, openMetricsAccept, "text/plain; version=0.0.4"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.AppEnabled = false
+			cfg.EnableOpenMetrics = tc.enabled
+			cfg.Traceparent = true
+			startRun(t, cfg)
+			request, err := http.NewRequest(http.MethodGet, "http://"+cfg.MetricsAddr+"/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Accept", tc.accept)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contentType := response.Header.Get("Content-Type")
+			if !strings.HasPrefix(contentType, tc.contentType) {
+				t.Fatalf("Content-Type %q, want %q", contentType, tc.contentType)
+			}
+			openMetrics := strings.HasPrefix(contentType, "application/openmetrics-text")
+			if ended := strings.HasSuffix(string(body), "# EOF\n"); ended != openMetrics {
+				t.Errorf("body ends with # EOF = %v in %s", ended, contentType)
+			}
+			// exemplars only exist in OpenMetrics
+			if traced := strings.Contains(string(body), `# {trace_id="`); traced != openMetrics {
+				t.Errorf("trace ID exemplars = %v in %s:\n%s", traced, contentType, body)
+			}
+			if openMetrics {
+				return
+			}
+			var parser expfmt.TextParser
+			if _, err := parser.TextToMetricFamilies(bytes.NewReader(body)); err != nil {
+				t.Errorf("invalid text format: %v", err)
+			}
+		})
+	}
+}
+
+func TestTargetMethod(t *testing.T) {
+	var received atomic.Value
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received.Store(r.Method + " " + r.Header.Get("Content-Type") + " " + string(body))
+		w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+	}))
+	defer target.Close()
+	cfg := testConfig(t)
+	cfg.AppEnabled = false
+	cfg.TargetURL = target.URL
+	cfg.TargetMethod = http.MethodPost
+	cfg.TargetBody = `{"counters": ["200", "500"]}`
+	startRun(t, cfg)
+	if up := scrapeValue(t, cfg, "httpserver_up"); up != 1 {
+		t.Errorf("httpserver_up = %v, want 1", up)
+	}
+	if want := `POST application/json {"counters": ["200", "500"]}`; received.Load() != want {
+		t.Errorf("target received %q, want %q", received.Load(), want)
+	}
+}
+
+func TestTargetBodyWithGet(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AppEnabled = false
+	cfg.TargetBody = "{}"
+	if err := Run(context.Background(), cfg); err == nil {
+		t.Error("GET with a body accepted")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// registerPprof adds the pprof handlers to m, reachable only from the
+// networks or addresses in allowFrom when it is not empty
+func registerPprof(m *http.ServeMux, allowFrom []string) error {
+	nets, err := parseAllowFrom(allowFrom)
+	if err != nil {
+		return err
+	}
+	handlers := map[string]http.HandlerFunc{
+		"/debug/pprof/":        pprof.Index,
+		"/debug/pprof/cmdline": pprof.Cmdline,
+		"/debug/pprof/profile": pprof.Profile,
+		"/debug/pprof/symbol":  pprof.Symbol,
+		"/debug/pprof/trace":   pprof.Trace,
+	}
+	for pattern, handler := range handlers {
+		m.Handle(pattern, allowedFrom(nets, handler))
+	}
+	return nil
+}
+
+// parseAllowFrom parses CIDRs, taking plain addresses as single hosts
+func parseAllowFrom(allowFrom []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range allowFrom {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid pprof allow-from address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pprof allow-from network %q: %w", s, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// allowedFrom answers 403 to clients outside nets, allowing all when nets is empty
+func allowedFrom(nets []*net.IPNet, next http.Handler) http.Handler {
+	if len(nets) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, n := range nets {
+				if n.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPprofEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		status  int
+	}{
+		{"disabled", false, http.StatusNotFound},
+		{"enabled", true, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.AppEnabled = false
+			cfg.EnablePprof = tc.enabled
+			startRun(t, cfg)
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+				if status, _ := get(t, "http://"+cfg.MetricsAddr+path); status != tc.status {
+					t.Errorf("GET %s: status %d, want %d", path, status, tc.status)
+				}
+			}
+		})
+	}
+}
+
+func TestPprofAllowFrom(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		allowFrom  []string
+		remoteAddr string
+		status     int
+	}{
+		{"all_allowed", nil, "192.0.2.1:1234", http.StatusOK},
+		{"address", []string{"127.0.0.1"}, "127.0.0.1:1234", http.StatusOK},
+		{"other_address", []string{"127.0.0.1"}, "127.0.0.2:1234", http.StatusForbidden},
+		{"network", []string{"10.0.0.0/8", "192.0.2.0/24"}, "192.0.2.1:1234", http.StatusOK},
+		{"outside_network", []string{"10.0.0.0/8"}, "192.0.2.1:1234", http.StatusForbidden},
+		{"ipv6", []string{"::1"}, "[::1]:1234", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := http.NewServeMux()
+			if err := registerPprof(m, tc.allowFrom); err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+			request.RemoteAddr = tc.remoteAddr
+			recorder := httptest.NewRecorder()
+			m.ServeHTTP(recorder, request)
+			if recorder.Code != tc.status {
+				t.Errorf("status %d, want %d", recorder.Code, tc.status)
+			}
+		})
+	}
+}
+
+func TestPprofAllowFromInvalid(t *testing.T) {
+	for _, allowFrom := range []string{"localhost", "10.0.0.0/33"} {
+		if err := registerPprof(http.NewServeMux(), []string{allowFrom}); err == nil {
+			t.Errorf("invalid allow-from %q accepted", allowFrom)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// pushRetries is the number of retries of a failed push, waiting twice as
+// long before each, starting at pushBackoff
+const (
+	pushRetries = 3
+	pushBackoff = time.Second
+)
+
+// pusher pushes the metrics of a registry to a Pushgateway
+type pusher struct {
+	pusher   *push.Pusher
+	failures prometheus.Counter
+}
+
+// newPusher registers exporter_push_failures_total on registry and pushes
+// all of its metrics as job, grouped by the name=value pairs of grouping
+func newPusher(url, job string, grouping []string, registry *prometheus.Registry) (*pusher, error) {
+	p := push.New(url, job).Gatherer(registry)
+	for _, pair := range grouping {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !model.LabelName(parts[0]).IsValid() {
+			return nil, fmt.Errorf("push grouping %q is not of the form name=value", pair)
+		}
+		p = p.Grouping(parts[0], parts[1])
+	}
+	failures := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_push_failures_total",
+		Help: "Number of failed pushes to the Pushgateway, retries included.",
+	})
+	if err := registry.Register(failures); err != nil {
+		return nil, err
+	}
+	return &pusher{pusher: p, failures: failures}, nil
+}
+
+// run pushes every interval until ctx is done
+func (p *pusher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.pushWithRetry(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pushWithRetry pushes, retrying with backoff while ctx allows
+func (p *pusher) pushWithRetry(ctx context.Context) {
+	backoff := pushBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.push(ctx)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			// shutting down, the final push follows
+			return
+		}
+		if attempt == pushRetries {
+			log.Errorf("Failed pushing metrics: %v", err)
+			return
+		}
+		log.Warnf("Failed pushing metrics, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// push pushes once, replacing the metrics of the group
+func (p *pusher) push(ctx context.Context) error {
+	if err := p.pusher.PushContext(ctx); err != nil {
+		p.failures.Inc()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPusherPushesRegistry(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+	}))
+	defer target.Close()
+	type pushed struct {
+		method, path string
+		families     map[string]*dto.MetricFamily
+	}
+	pushes := make(chan pushed, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families := map[string]*dto.MetricFamily{}
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			family := &dto.MetricFamily{}
+			if err := decoder.Decode(family); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("decoding push: %v", err)
+				break
+			}
+			families[family.GetName()] = family
+		}
+		pushes <- pushed{r.Method, r.URL.Path, families}
+	}))
+	defer gateway.Close()
+
+	exporter, err := collector.NewCollector(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+	p, err := newPusher(gateway.URL, "test_job", []string{"instance=a"}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	push := <-pushes
+	if push.method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", push.method)
+	}
+	if want := "/metrics/job/test_job/instance/a"; push.path != want {
+		t.Errorf("path = %s, want %s", push.path, want)
+	}
+	up, ok := push.families["httpserver_up"]
+	if !ok {
+		t.Fatalf("push lacks httpserver_up, got %d families", len(push.families))
+	}
+	if value := up.GetMetric()[0].GetGauge().GetValue(); value != 1 {
+		t.Errorf("httpserver_up = %v, want 1", value)
+	}
+	if _, ok := push.families["exporter_push_failures_total"]; !ok {
+		t.Error("push lacks exporter_push_failures_total")
+	}
+}
+
+func TestPusherCountsFailures(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer gateway.Close()
+	p, err := newPusher(gateway.URL, "test_job", nil, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.push(context.Background()); err == nil {
+		t.Fatal("push to a failing gateway succeeded")
+	}
+	if failures := testutil.ToFloat64(p.failures); failures != 1 {
+		t.Errorf("exporter_push_failures_total = %v, want 1", failures)
+	}
+}
+
+func TestNewPusherGrouping(t *testing.T) {
+	for _, tc := range []struct {
+		grouping []string
+		valid    bool
+	}{
+		{nil, true},
+		{[]string{"instance=a", "zone=eu-west"}, true},
+		{[]string{"instance="}, true},
+		{[]string{"instance"}, false},
+		{[]string{"0zone=eu"}, false},
+	} {
+		_, err := newPusher("http://pushgateway:9091", "job", tc.grouping, prometheus.NewRegistry())
+		if (err == nil) != tc.valid {
+			t.Errorf("newPusher(%q) error = %v, want valid %v", tc.grouping, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+)
+
+// readiness decides whether the metrics server should receive traffic: after
+// the collector is registered, optionally once the target has been scraped,
+// and no longer once shutdown began
+type readiness struct {
+	// registered and shuttingDown are accessed atomically
+	registered     int32
+	shuttingDown   int32
+	requiresTarget bool
+	exporter       *collector.Collector
+}
+
+// setRegistered marks the collector as registered
+func (r *readiness) setRegistered() {
+	atomic.StoreInt32(&r.registered, 1)
+}
+
+// shutdown makes the server unready so load balancers drain it
+func (r *readiness) shutdown() {
+	atomic.StoreInt32(&r.shuttingDown, 1)
+}
+
+// ServeHTTP
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case atomic.LoadInt32(&r.shuttingDown) == 1:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Shutting Down")
+	case atomic.LoadInt32(&r.registered) == 0, r.requiresTarget && !r.exporter.Ready():
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Not Ready")
+	default:
+		fmt.Fprint(w, "OK")
+	}
+}
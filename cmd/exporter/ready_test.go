@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReadiness(t *testing.T) {
+	var targetUp int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&targetUp) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+	}))
+	defer target.Close()
+	exporter, err := collector.NewCollector(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := &readiness{requiresTarget: true, exporter: exporter}
+	check := func(step string, status int, body string) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		ready.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+		if recorder.Code != status || recorder.Body.String() != body {
+			t.Errorf("%s: %d %q, want %d %q", step, recorder.Code, recorder.Body.String(), status, body)
+		}
+	}
+
+	check("starting", http.StatusServiceUnavailable, "Not Ready")
+	ready.setRegistered()
+	check("registered", http.StatusServiceUnavailable, "Not Ready")
+	testutil.CollectAndCount(exporter)
+	check("target down", http.StatusServiceUnavailable, "Not Ready")
+	atomic.StoreInt32(&targetUp, 1)
+	testutil.CollectAndCount(exporter)
+	check("target up", http.StatusOK, "OK")
+	ready.shutdown()
+	check("shutting down", http.StatusServiceUnavailable, "Shutting Down")
+
+	ready = &readiness{exporter: exporter}
+	ready.setRegistered()
+	check("not requiring the target", http.StatusOK, "OK")
+}
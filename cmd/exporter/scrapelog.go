@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// logScrapes logs every request to next with the client, status and duration
+func logScrapes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.WithFields(log.Fields{
+			"client":      clientIP(r),
+			"remote_addr": r.RemoteAddr,
+			"path":        r.URL.Path,
+			"status":      rec.status,
+			"duration":    time.Since(start).Seconds(),
+		}).Info("Served scrape")
+	})
+}
+
+// clientIP is the first address of X-Forwarded-For when a proxy set it,
+// the remote address otherwise
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
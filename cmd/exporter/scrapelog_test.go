@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogScrapes(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		status     int
+		client     string
+	}{
+		{"direct", "192.0.2.10:51234", "", http.StatusOK, "192.0.2.10"},
+		{"proxied", "10.0.0.1:443", "203.0.113.7, 10.0.0.1", http.StatusOK, "203.0.113.7"},
+		{"error_status", "[2001:db8::1]:8080", "", http.StatusServiceUnavailable, "2001:db8::1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+			handler := logScrapes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			request.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				request.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			entry := hook.LastEntry()
+			if entry == nil {
+				t.Fatal("no log entry")
+			}
+			if entry.Level != log.InfoLevel {
+				t.Errorf("level = %s, want info", entry.Level)
+			}
+			for field, want := range map[string]interface{}{
+				"client":      tc.client,
+				"remote_addr": tc.remoteAddr,
+				"path":        "/metrics",
+				"status":      tc.status,
+			} {
+				if got := entry.Data[field]; got != want {
+					t.Errorf("%s = %v, want %v", field, got, want)
+				}
+			}
+			if duration, ok := entry.Data["duration"].(float64); !ok || duration < 0 {
+				t.Errorf("duration = %v, want seconds", entry.Data["duration"])
+			}
+		})
+	}
+}
+
+func TestLogScrapesFlag(t *testing.T) {
+	for _, tc := range []struct {
+		enabled bool
+		entries int
+	}{
+		{false, 0},
+		{true, 1},
+	} {
+		hook := test.NewGlobal()
+		cfg := flagConfig()
+		cfg.LogScrapes = tc.enabled
+		router, err := metricsRouter(cfg, prometheus.NewRegistry(), nil, &readiness{}, nil, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		served := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "Served scrape" {
+				served++
+			}
+		}
+		if served != tc.entries {
+			t.Errorf("-web.log-scrapes=%v: %d scrapes logged, want %d", tc.enabled, served, tc.entries)
+		}
+		hook.Reset()
+	}
+}
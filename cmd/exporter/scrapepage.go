@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	log "github.com/sirupsen/logrus"
+)
+
+// scrapeResult is the JSON form of a collector.PathResult
+type scrapeResult struct {
+	Path            string                                `json:"path"`
+	StatusCode      int                                   `json:"status_code,omitempty"`
+	DurationSeconds float64                               `json:"duration_seconds"`
+	Stats           map[string]json.RawMessage            `json:"stats,omitempty"`
+	Rows            map[string]map[string]json.RawMessage `json:"rows,omitempty"`
+	Families        []string                              `json:"families,omitempty"`
+	Error           string                                `json:"error,omitempty"`
+}
+
+// scrapeHandler fetches every path of the target on GET and answers with
+// what was decoded or the errors, 502 when a path failed
+func scrapeHandler(exporter *collector.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Only GET requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status := http.StatusOK
+		results := []scrapeResult{}
+		for _, result := range exporter.Scrape(r.Context()) {
+			out := scrapeResult{
+				Path:            result.Path,
+				StatusCode:      result.StatusCode,
+				DurationSeconds: result.Duration.Seconds(),
+				Stats:           result.Stats,
+				Rows:            result.Rows,
+				Families:        result.Families,
+			}
+			if result.Err != nil {
+				out.Error = result.Err.Error()
+				status = http.StatusBadGateway
+			}
+			results = append(results, out)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			log.Errorf("Failed writing scrape results: %v", err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+)
+
+func TestScrapeHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		targetStatus int
+		status       int
+		failed       bool
+	}{
+		{"success", http.StatusOK, http.StatusOK, false},
+		{"bad_status", http.StatusInternalServerError, http.StatusBadGateway, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.targetStatus)
+				w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+			}))
+			defer target.Close()
+			exporter, err := collector.NewCollector(target.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			scrapeHandler(exporter)(recorder, httptest.NewRequest(http.MethodGet, "/-/scrape", nil))
+			if recorder.Code != tc.status {
+				t.Errorf("status %d, want %d", recorder.Code, tc.status)
+			}
+			var results []scrapeResult
+			if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+				t.Fatalf("invalid JSON %s: %v", recorder.Body, err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("%d results, want 1 for /stats", len(results))
+			}
+			result := results[0]
+			if result.Path != "/stats" || result.StatusCode != tc.targetStatus {
+				t.Errorf("result for %s with status %d, want /stats with %d", result.Path, result.StatusCode, tc.targetStatus)
+			}
+			if failed := result.Error != ""; failed != tc.failed {
+				t.Errorf("error %q, want failed = %v", result.Error, tc.failed)
+			}
+			if tc.failed {
+				return
+			}
+			if value := string(result.Stats["http200Requestcounter"]); value != "5" {
+				t.Errorf("http200Requestcounter = %s, want 5", value)
+			}
+		})
+	}
+}
+
+func TestScrapeHandlerMethod(t *testing.T) {
+	exporter, err := collector.NewCollector("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	scrapeHandler(exporter)(recorder, httptest.NewRequest(http.MethodPost, "/-/scrape", nil))
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("POST: status %d, Allow %q", recorder.Code, recorder.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "errors"
+
+// runUntilStopped runs the exporter until SIGINT or SIGTERM
+func runUntilStopped(cfg Config) error {
+	return runUntilSignalled(cfg)
+}
+
+// controlService is only available on Windows
+func controlService(string, []string) error {
+	return errors.New("-service is only available on Windows")
+}
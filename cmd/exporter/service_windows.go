@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	// serviceName names the Windows service and its event log source
+	serviceName = "simple-prometheus-exporter"
+	// serviceStopTimeout bounds waiting for the service to stop
+	serviceStopTimeout = 30 * time.Second
+)
+
+// runUntilStopped runs the exporter as a Windows service until the service
+// manager stops it, or until Ctrl+C when started from a console
+func runUntilStopped(cfg Config) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return fmt.Errorf("failed detecting Windows service: %w", err)
+	}
+	if !isService {
+		return runUntilSignalled(cfg)
+	}
+	// a service has no console to log to, the event log replaces it unless
+	// -log.output names a file
+	if logging.toConsole() {
+		if events, err := eventlog.Open(serviceName); err != nil {
+			log.Warnf("Failed opening event log, logging to stderr: %v", err)
+		} else {
+			defer events.Close()
+			log.AddHook(eventLogHook{events})
+			log.SetOutput(io.Discard)
+		}
+	}
+	return svc.Run(serviceName, &exporterService{cfg: cfg})
+}
+
+// exporterService runs the exporter for the Windows service manager
+type exporterService struct {
+	cfg Config
+	err error
+}
+
+// Execute runs the exporter, shutting it down gracefully on stop and
+// shutdown requests
+func (s *exporterService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, s.cfg) }()
+	running := svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	status <- running
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Error(err)
+				// a service specific exit code
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Info("Stop requested by the service manager")
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
+
+// eventLogHook writes log entries to the Windows event log
+type eventLogHook struct {
+	events *eventlog.Log
+}
+
+// Levels
+func (h eventLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire
+func (h eventLogHook) Fire(entry *log.Entry) error {
+	message, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel, log.ErrorLevel:
+		return h.events.Error(1, message)
+	case log.WarnLevel:
+		return h.events.Warning(1, message)
+	default:
+		return h.events.Info(1, message)
+	}
+}
+
+// controlService installs, uninstalls, starts or stops the Windows service,
+// installing it with the flags of args
+func controlService(action string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed connecting to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if action == "install" {
+		return installService(m, args)
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	switch action {
+	case "uninstall":
+		if err := s.Delete(); err != nil {
+			return err
+		}
+		if err := eventlog.Remove(serviceName); err != nil {
+			log.Warnf("Failed removing event log source: %v", err)
+		}
+		log.Infof("Uninstalled service %s", serviceName)
+	case "start":
+		if err := s.Start(); err != nil {
+			return err
+		}
+		log.Infof("Started service %s", serviceName)
+	case "stop":
+		current, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(serviceStopTimeout)
+		for current.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("service %s did not stop within %s", serviceName, serviceStopTimeout)
+			}
+			time.Sleep(300 * time.Millisecond)
+			if current, err = s.Query(); err != nil {
+				return err
+			}
+		}
+		log.Infof("Stopped service %s", serviceName)
+	default:
+		return fmt.Errorf("unknown -service action %q, expected install, uninstall, start or stop", action)
+	}
+	return nil
+}
+
+// installService registers the service to start automatically with args
+// but -service, and its event log source
+func installService(m *mgr.Mgr, args []string) error {
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is installed already", serviceName)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Simple Prometheus exporter",
+		Description: "Exports the stats of an HTTP server as Prometheus metrics.",
+		StartType:   mgr.StartAutomatic,
+	}, serviceArgs(args)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed installing event log source: %w", err)
+	}
+	log.Infof("Installed service %s running %s", serviceName, exe)
+	return nil
+}
+
+// serviceArgs drops the -service flag and its value from args
+func serviceArgs(args []string) []string {
+	kept := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		switch {
+		case args[i] == "--" || !strings.HasPrefix(args[i], "-"):
+			// flags end here
+			return append(kept, args[i:]...)
+		case name == "service":
+			// the value is the next argument
+			i++
+		case strings.HasPrefix(name, "service="):
+		default:
+			kept = append(kept, args[i])
+		}
+	}
+	return kept
+}
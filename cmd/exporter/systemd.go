@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// systemd socket activation and service notification as described in
+// sd_listen_fds(3) and sd_notify(3). Without LISTEN_FDS and NOTIFY_SOCKET,
+// as everywhere outside systemd, none of it has any effect.
+
+// listenFDsStart is the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// activation holds the sockets passed by systemd until the servers take them
+type activation struct {
+	listeners []net.Listener
+}
+
+// activatedListeners returns the sockets passed to this process by systemd,
+// none when it was not socket activated
+func activatedListeners() (*activation, error) {
+	a := &activation{}
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return a, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return a, nil
+	}
+	// not for child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// FileListener works on a duplicate of the descriptor
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d passed by systemd: %w", fd, err)
+		}
+		a.listeners = append(a.listeners, ln)
+	}
+	return a, nil
+}
+
+// take returns the passed socket listening on addr and hands it out only
+// once, nil when there is none
+func (a *activation) take(addr string) net.Listener {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	number, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return nil
+	}
+	for i, ln := range a.listeners {
+		tcpAddr, ok := ln.Addr().(*net.TCPAddr)
+		if !ok || tcpAddr.Port != number || !matchesHost(host, tcpAddr.IP) {
+			continue
+		}
+		a.listeners = append(a.listeners[:i], a.listeners[i+1:]...)
+		return ln
+	}
+	return nil
+}
+
+// matchesHost reports whether a socket bound to ip serves the host of a
+// listen address, any host for an empty one
+func matchesHost(host string, ip net.IP) bool {
+	switch {
+	case host == "":
+		return true
+	case host == "localhost":
+		return ip.IsLoopback() || ip.IsUnspecified()
+	}
+	hostIP := net.ParseIP(host)
+	return hostIP != nil && (hostIP.Equal(ip) || ip.IsUnspecified())
+}
+
+// unused returns the addresses of the passed sockets no server took
+func (a *activation) unused() []string {
+	addrs := make([]string, 0, len(a.listeners))
+	for _, ln := range a.listeners {
+		addrs = append(addrs, ln.Addr().String())
+	}
+	return addrs
+}
+
+// sdNotify sends state to the service manager, nothing without NOTIFY_SOCKET
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err == nil {
+		defer conn.Close()
+		_, err = conn.Write([]byte(state))
+	}
+	if err != nil {
+		log.Warnf("Failed notifying systemd of %s: %v", state, err)
+	}
+}
+
+// runWatchdog pings the systemd watchdog at half its timeout until ctx is
+// done, when WatchdogSec is set for the service
+func runWatchdog(ctx context.Context) {
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sdNotify("WATCHDOG=1")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// targetTLS holds the client TLS settings for the target. The CA and client
+// certificate files can be reloaded while connections are being made.
+type targetTLS struct {
+	caFile             string
+	certFile           string
+	keyFile            string
+	serverName         string
+	insecureSkipVerify bool
+
+	mu         sync.RWMutex
+	roots      *x509.CertPool
+	cert       *tls.Certificate
+	transports []*tlsTransport
+}
+
+// newTargetTLS loads the configured files, failing if any of them is unusable
+func newTargetTLS(cfg Config) (*targetTLS, error) {
+	t := &targetTLS{
+		caFile:             cfg.TLSCAFile,
+		certFile:           cfg.TLSCertFile,
+		keyFile:            cfg.TLSKeyFile,
+		serverName:         cfg.TLSServerName,
+		insecureSkipVerify: cfg.TLSInsecureSkipVerify,
+	}
+	if (t.certFile == "") != (t.keyFile == "") {
+		return nil, errors.New("client certificate and key files must be given together")
+	}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// enabled reports whether any TLS option was configured
+func (t *targetTLS) enabled() bool {
+	return t.caFile != "" || t.certFile != "" || t.serverName != "" || t.insecureSkipVerify
+}
+
+// reload reads the CA and client certificate files again, keeping the
+// previous ones on error
+func (t *targetTLS) reload() error {
+	var roots *x509.CertPool
+	if t.caFile != "" {
+		pem, err := os.ReadFile(t.caFile)
+		if err != nil {
+			return fmt.Errorf("failed reading target CA file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no PEM certificates found in target CA file %s", t.caFile)
+		}
+	}
+	var cert *tls.Certificate
+	if t.certFile != "" {
+		c, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed loading target client certificate: %w", err)
+		}
+		cert = &c
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roots, t.cert = roots, cert
+	// the roots of a tls.Config cannot change once it is in use
+	for _, transport := range t.transports {
+		transport.replace(t.configLocked())
+	}
+	return nil
+}
+
+// config returns a tls.Config verifying the target against the loaded
+// roots, or the system roots without CA file, and the name it was dialed
+// by unless serverName is set. The client certificate is always the latest
+// loaded.
+func (t *targetTLS) config() *tls.Config {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.configLocked()
+}
+
+// configLocked is config with t.mu held
+func (t *targetTLS) configLocked() *tls.Config {
+	return &tls.Config{
+		ServerName:           t.serverName,
+		RootCAs:              t.roots,
+		InsecureSkipVerify:   t.insecureSkipVerify,
+		GetClientCertificate: t.clientCertificate,
+	}
+}
+
+// wrap returns a round tripper sending with a clone of transport that uses
+// config, cloned again with the new roots on every reload
+func (t *targetTLS) wrap(transport *http.Transport) http.RoundTripper {
+	wrapped := &tlsTransport{base: transport}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	wrapped.replace(t.configLocked())
+	t.transports = append(t.transports, wrapped)
+	return wrapped
+}
+
+// tlsTransport sends requests with the transport of the latest TLS config
+type tlsTransport struct {
+	// base is only cloned, never sent with
+	base *http.Transport
+
+	mu      sync.RWMutex
+	current *http.Transport
+}
+
+// replace switches to a clone of the base transport using config, closing
+// the idle connections of the previous one
+func (t *tlsTransport) replace(config *tls.Config) {
+	t.mu.Lock()
+	previous := t.current
+	t.current = t.base.Clone()
+	t.current.TLSClientConfig = config
+	t.mu.Unlock()
+	if previous != nil {
+		previous.CloseIdleConnections()
+	}
+}
+
+// RoundTrip
+func (t *tlsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	current := t.current
+	t.mu.RUnlock()
+	return current.RoundTrip(request)
+}
+
+// CloseIdleConnections
+func (t *tlsTransport) CloseIdleConnections() {
+	t.mu.RLock()
+	current := t.current
+	t.mu.RUnlock()
+	current.CloseIdleConnections()
+}
+
+// clientCertificate
+func (t *targetTLS) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return t.cert, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tlsTarget serves the certificate of certFile and keyFile
+func tlsTarget(t *testing.T, certFile, keyFile string) *httptest.Server {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// tlsClient returns a client of the target transport with the TLS settings of cfg
+func tlsClient(t *testing.T, cfg Config) (*http.Client, *targetTLS) {
+	t.Helper()
+	targetTLS, err := newTargetTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := newTargetTransport(cfg, &url.URL{Scheme: "https", Host: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: targetTLS.wrap(transport)}, targetTLS
+}
+
+func TestTargetTLSVerify(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name       string
+		hosts      []string
+		serverName string
+		insecure   bool
+		// trusted certificates are those of the CA file
+		untrusted bool
+		rejected  bool
+	}{
+		{name: "ip", hosts: []string{"127.0.0.1"}},
+		{name: "other_ip", hosts: []string{"10.0.0.1"}, rejected: true},
+		{name: "dns_name_for_ip", hosts: []string{"example.com"}, rejected: true},
+		{name: "server_name", hosts: []string{"example.com"}, serverName: "example.com"},
+		{name: "wrong_server_name", hosts: []string{"example.com"}, serverName: "example.org", rejected: true},
+		{name: "untrusted", hosts: []string{"127.0.0.1"}, untrusted: true, rejected: true},
+		{name: "insecure", hosts: []string{"10.0.0.1"}, untrusted: true, insecure: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			certFile, keyFile, _ := writeCert(t, dir, tc.name, tc.hosts...)
+			caFile := certFile
+			if tc.untrusted {
+				caFile, _, _ = writeCert(t, dir, tc.name+"_ca")
+			}
+			server := tlsTarget(t, certFile, keyFile)
+			cfg := flagConfig()
+			cfg.TLSCAFile, cfg.TLSServerName, cfg.TLSInsecureSkipVerify = caFile, tc.serverName, tc.insecure
+			client, _ := tlsClient(t, cfg)
+			response, err := client.Get(server.URL)
+			if err == nil {
+				response.Body.Close()
+			}
+			var verifyErr *tls.CertificateVerificationError
+			if tc.rejected && !errors.As(err, &verifyErr) {
+				t.Errorf("error = %v, want a failed verification", err)
+			}
+			if !tc.rejected && err != nil {
+				t.Errorf("request failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestTargetTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, "server")
+	otherFile, _, _ := writeCert(t, dir, "other")
+	caFile := filepath.Join(dir, "ca.crt")
+	copyFile := func(from string) {
+		content, err := os.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(caFile, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyFile(otherFile)
+	server := tlsTarget(t, certFile, keyFile)
+	cfg := flagConfig()
+	cfg.TLSCAFile = caFile
+	client, targetTLS := tlsClient(t, cfg)
+	if response, err := client.Get(server.URL); err == nil {
+		response.Body.Close()
+		t.Fatal("target verified against the wrong CA")
+	}
+	copyFile(certFile)
+	if err := targetTLS.reload(); err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("target not verified against the reloaded CA: %v", err)
+	}
+	response.Body.Close()
+}
+
+func TestTargetMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, "server")
+	clientCertFile, clientKeyFile, clientCert := writeCert(t, dir, "client")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name              string
+		certFile, keyFile string
+		rejected          bool
+	}{
+		{"client_cert", clientCertFile, clientKeyFile, false},
+		{"no_client_cert", "", "", true},
+		{"untrusted_client_cert", certFile, keyFile, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := flagConfig()
+			cfg.TLSCAFile, cfg.TLSCertFile, cfg.TLSKeyFile = certFile, tc.certFile, tc.keyFile
+			client, _ := tlsClient(t, cfg)
+			response, err := client.Get(server.URL)
+			if err == nil {
+				response.Body.Close()
+			}
+			if rejected := err != nil; rejected != tc.rejected {
+				t.Errorf("request rejected = %v, want %v: %v", rejected, tc.rejected, err)
+			}
+		})
+	}
+}
+
+func TestTargetTLSCertWithoutKey(t *testing.T) {
+	certFile, _, _ := writeCert(t, t.TempDir(), "client")
+	cfg := flagConfig()
+	cfg.TLSCertFile = certFile
+	if _, err := newTargetTLS(cfg); err == nil {
+		t.Error("client certificate without key accepted")
+	}
+}
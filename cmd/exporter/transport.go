@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+// newTargetTransport builds the transport used for requests to the target.
+// Proxies come from HTTP_PROXY, HTTPS_PROXY and NO_PROXY unless a proxy URL
+// is configured, which may carry basic auth credentials.
+func newTargetTransport(cfg Config, targetURL *url.URL) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.DisableKeepAlives = cfg.DisableKeepAlives
+	transport.ForceAttemptHTTP2 = cfg.ForceAttemptHTTP2
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if cfg.UnixSocket != "" {
+		transport.Proxy = nil
+		transport.DialContext = unixSocketDialer(cfg.UnixSocket)
+		log.Debugf("Connecting to target through unix socket %s", cfg.UnixSocket)
+		return transport, nil
+	}
+
+	if cfg.DNSCacheTTL > 0 {
+		transport.DialContext = newDNSCache(cfg.DNSCacheTTL).DialContext
+		log.Debugf("Caching DNS lookups for %s", cfg.DNSCacheTTL)
+	}
+
+	proxy, err := transport.Proxy(&http.Request{URL: targetURL})
+	switch {
+	case err != nil:
+		log.Debugf("Could not determine proxy for target %s: %v", targetURL.Redacted(), err)
+	case proxy == nil:
+		log.Debugf("Connecting to target %s directly", targetURL.Redacted())
+	default:
+		log.Debugf("Connecting to target %s through proxy %s", targetURL.Redacted(), proxy.Redacted())
+	}
+	return transport, nil
+}
+
+// enableHTTP2 negotiates HTTP/2 with TLS targets and speaks HTTP/2 without
+// TLS (h2c) to plaintext targets, reusing the transport's dialer
+func enableHTTP2(transport *http.Transport, targetURL *url.URL) http.RoundTripper {
+	if targetURL.Scheme == "https" {
+		transport.ForceAttemptHTTP2 = true
+		return transport
+	}
+	dial := transport.DialContext
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+}
+
+// unixSocketDialer connects to the socket at path whatever address is requested
+func unixSocketDialer(path string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, "unix", path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return nil, fmt.Errorf("unix socket %s does not exist: %w", path, err)
+		case errors.Is(err, os.ErrPermission):
+			return nil, fmt.Errorf("permission denied connecting to unix socket %s: %w", path, err)
+		}
+		return conn, err
+	}
+}
+
+// splitUnixTarget turns a unix:///path/to.sock:/stats target into the socket
+// path and an HTTP base URL with a placeholder host
+func splitUnixTarget(target *url.URL) (string, *url.URL) {
+	socket, path := target.Path, ""
+	if i := strings.Index(socket, ":"); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+	}
+	// the stats path is appended when fetching
+	path = strings.TrimSuffix(path, "/stats")
+	return socket, &url.URL{Scheme: "http", Host: "unix", Path: path}
+}
+
+// redirectPolicy follows at most maxRedirects redirects, staying on the
+// original host unless allowCrossHost is set. A chain of exactly
+// maxRedirects redirects succeeds, one more fails the request.
+func redirectPolicy(maxRedirects int, allowCrossHost bool) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		chain := make([]string, 0, len(via)+1)
+		for _, r := range via {
+			chain = append(chain, r.URL.Redacted())
+		}
+		chain = append(chain, req.URL.Redacted())
+		log.Debugf("Following redirect chain %s", strings.Join(chain, " -> "))
+
+		// via holds the requests already sent, so this is redirect len(via)
+		if len(via) > maxRedirects {
+			return fmt.Errorf("%w: more than %d redirects", collector.ErrRedirectRefused, maxRedirects)
+		}
+		if !allowCrossHost && req.URL.Host != via[0].URL.Host {
+			return fmt.Errorf("%w: cross-host redirect from %s to %s", collector.ErrRedirectRefused, via[0].URL.Host, req.URL.Host)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// redirectServer redirects /redirect/n to /redirect/n-1 and answers
+// /redirect/0, so a request of /redirect/n is redirected n times
+func redirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n > 0 {
+			http.Redirect(w, r, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	server := redirectServer(t)
+	for _, tc := range []struct {
+		name         string
+		maxRedirects int
+		redirects    int
+		refused      bool
+	}{
+		{name: "none_allowed_none_sent", maxRedirects: 0, redirects: 0},
+		{name: "none_allowed", maxRedirects: 0, redirects: 1, refused: true},
+		{name: "at_limit", maxRedirects: 3, redirects: 3},
+		{name: "over_limit", maxRedirects: 3, redirects: 4, refused: true},
+		{name: "default_at_limit", maxRedirects: defaultMaxRedirects, redirects: defaultMaxRedirects},
+		{name: "default_over_limit", maxRedirects: defaultMaxRedirects, redirects: defaultMaxRedirects + 1, refused: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{CheckRedirect: redirectPolicy(tc.maxRedirects, false)}
+			response, err := client.Get(server.URL + "/redirect/" + strconv.Itoa(tc.redirects))
+			if tc.refused {
+				if !errors.Is(err, collector.ErrRedirectRefused) {
+					t.Errorf("error = %v, want a refused redirect", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.Request.URL.Path != "/redirect/0" {
+				t.Errorf("ended at %s, want /redirect/0", response.Request.URL.Path)
+			}
+		})
+	}
+}
+
+func TestRedirectPolicyCrossHost(t *testing.T) {
+	other := redirectServer(t)
+	server := httptest.NewServer(http.RedirectHandler(other.URL+"/redirect/0", http.StatusFound))
+	defer server.Close()
+	for _, allow := range []bool{false, true} {
+		client := &http.Client{CheckRedirect: redirectPolicy(defaultMaxRedirects, allow)}
+		response, err := client.Get(server.URL)
+		if err == nil {
+			response.Body.Close()
+		}
+		if refused := errors.Is(err, collector.ErrRedirectRefused); refused == allow {
+			t.Errorf("cross-host redirect allowed = %v: error = %v", allow, err)
+		}
+	}
+}
+
+func TestTargetProxy(t *testing.T) {
+	var requested, authorization string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested, authorization = r.URL.String(), r.Header.Get("Proxy-Authorization")
+		w.Write([]byte(`{"http200Requestcounter": 5}`))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL.User = url.UserPassword("proxy", "s3cret")
+	targetURL, err := url.Parse("http://stats.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := flagConfig()
+	cfg.ProxyURL = proxyURL.String()
+	transport, err := newTargetTransport(cfg, targetURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := (&http.Client{Transport: transport}).Get(targetURL.String() + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if requested != "http://stats.invalid/stats" {
+		t.Errorf("proxy received a request for %q, want http://stats.invalid/stats", requested)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("proxy:s3cret")); authorization != want {
+		t.Errorf("Proxy-Authorization = %q, want %q", authorization, want)
+	}
+}
+
+func TestEnableHTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	for _, tc := range []struct {
+		name   string
+		server func() *httptest.Server
+	}{
+		{"tls", func() *httptest.Server {
+			server := httptest.NewUnstartedServer(handler)
+			server.EnableHTTP2 = true
+			server.StartTLS()
+			return server
+		}},
+		{"h2c", func() *httptest.Server {
+			return httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := tc.server()
+			defer server.Close()
+			targetURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transport, err := newTargetTransport(flagConfig(), targetURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if server.TLS != nil {
+				transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+			}
+			client := &http.Client{Transport: enableHTTP2(transport, targetURL)}
+			response, err := client.Get(server.URL + "/stats")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			proto, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.ProtoMajor != 2 || string(proto) != "HTTP/2.0" {
+				t.Errorf("response over %s to a request over %s, want HTTP/2.0", response.Proto, proto)
+			}
+		})
+	}
+}
+
+func TestTargetKeepAlives(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		disableKeepAlives bool
+		connections       int32
+	}{
+		{"reused", false, 1},
+		{"disabled", true, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var connections int32
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("{}"))
+			}))
+			server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					atomic.AddInt32(&connections, 1)
+				}
+			}
+			server.Start()
+			defer server.Close()
+			targetURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := flagConfig()
+			cfg.DisableKeepAlives = tc.disableKeepAlives
+			transport, err := newTargetTransport(cfg, targetURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: transport}
+			for i := 0; i < 3; i++ {
+				response, err := client.Get(server.URL + "/stats")
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, response.Body)
+				response.Body.Close()
+			}
+			if n := atomic.LoadInt32(&connections); n != tc.connections {
+				t.Errorf("3 requests opened %d connections, want %d", n, tc.connections)
+			}
+		})
+	}
+}
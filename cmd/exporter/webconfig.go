@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// webConfigYAML is the subset of the exporter-toolkit web config file applied
+// to the metrics server
+type webConfigYAML struct {
+	TLSServerConfig *tlsServerConfig `yaml:"tls_server_config"`
+	// BasicAuthUsers maps user names to bcrypt hashes of their passwords
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+type tlsServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	MinVersion     string `yaml:"min_version"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":      tls.VersionTLS12,
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// webConfig serves the metrics server with the TLS and basic auth settings
+// of a web config file, which is read again on reload
+type webConfig struct {
+	file string
+
+	mu     sync.RWMutex
+	loaded bool
+	tls    *tls.Config
+	users  map[string][]byte
+}
+
+// loadWebConfig reads and validates a web config file
+func loadWebConfig(file string) (*webConfig, error) {
+	w := &webConfig{file: file}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// reload reads the config file, certificates and client CA again, keeping
+// the previous settings on error. TLS cannot be turned on or off by a reload.
+func (w *webConfig) reload() error {
+	content, err := os.ReadFile(w.file)
+	if err != nil {
+		return fmt.Errorf("failed reading web config: %w", err)
+	}
+	var cfg webConfigYAML
+	if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+		return fmt.Errorf("failed parsing web config %s: %w", w.file, err)
+	}
+	users := map[string][]byte{}
+	for user, hash := range cfg.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("invalid bcrypt hash for user %q in web config: %w", user, err)
+		}
+		users[user] = []byte(hash)
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLSServerConfig != nil {
+		tlsConfig, err = cfg.TLSServerConfig.load()
+		if err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.loaded && (w.tls == nil) != (tlsConfig == nil) {
+		return errors.New("tls_server_config cannot be added or removed on reload")
+	}
+	w.loaded, w.tls, w.users = true, tlsConfig, users
+	return nil
+}
+
+// load reads the certificate, key and client CA files
+func (c *tlsServerConfig) load() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls_server_config needs cert_file and key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading server certificate: %w", err)
+	}
+	clientAuth, ok := clientAuthTypes[c.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q", c.ClientAuthType)
+	}
+	minVersion, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid min_version %q, expected TLS10, TLS11, TLS12 or TLS13", c.MinVersion)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		MinVersion:   minVersion,
+	}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading client CA file: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in client CA file %s", c.ClientCAFile)
+		}
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s needs client_ca_file", c.ClientAuthType)
+	}
+	return config, nil
+}
+
+// probeTLSConfig returns the client TLS settings of a probe of a server
+// with the web config in file, trusting its certificate besides the system
+// roots, or nil when the server is not served over TLS
+func probeTLSConfig(file string, insecureSkipVerify bool) (*tls.Config, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading web config: %w", err)
+	}
+	var cfg webConfigYAML
+	if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+		return nil, fmt.Errorf("failed parsing web config %s: %w", file, err)
+	}
+	if cfg.TLSServerConfig == nil {
+		return nil, nil
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if cfg.TLSServerConfig.CertFile != "" {
+		pem, err := os.ReadFile(cfg.TLSServerConfig.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading server certificate: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in server certificate file %s", cfg.TLSServerConfig.CertFile)
+		}
+	}
+	return &tls.Config{RootCAs: roots, InsecureSkipVerify: insecureSkipVerify}, nil
+}
+
+// tlsEnabled reports whether the server is served over TLS
+func (w *webConfig) tlsEnabled() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.tls != nil
+}
+
+// serverTLSConfig returns a tls.Config that always uses the latest loaded settings
+func (w *webConfig) serverTLSConfig() *tls.Config {
+	current := func() *tls.Config {
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		return w.tls
+	}
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return current(), nil
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &current().Certificates[0], nil
+		},
+	}
+}
+
+// handler requires basic auth for next when users are configured
+func (w *webConfig) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.mu.RLock()
+		users := w.users
+		w.mu.RUnlock()
+		if len(users) == 0 {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		user, password, ok := r.BasicAuth()
+		hash, known := users[user]
+		if !known {
+			// compare anyway so unknown users take as long as wrong passwords
+			hash = dummyHash
+		}
+		err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+		if !ok || !known || err != nil {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="exporter"`)
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// dummyHash is compared against for unknown users
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCert writes a self-signed certificate for hosts, 127.0.0.1 when none
+// are given, and its key to dir, returning their files and the certificate
+func writeCert(t *testing.T, dir, name string, hosts ...string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.1"}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// writeWebConfig writes content to a web config file in dir
+func writeWebConfig(t *testing.T, dir, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, "web.yml")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, "server")
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n"
+	for _, tc := range []struct {
+		name    string
+		content string
+		tls     bool
+		users   int
+		err     string
+	}{
+		{name: "empty", content: ""},
+		{name: "basic_auth", content: "basic_auth_users:\n  admin: " + string(hash) + "\n", users: 1},
+		{name: "tls", content: tlsConfig, tls: true},
+		{name: "tls_client_auth", content: tlsConfig + "  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: " + certFile + "\n  min_version: TLS13\n", tls: true},
+		{name: "unknown_field", content: "basic_auth:\n  admin: x\n", err: "failed parsing web config"},
+		{name: "invalid_hash", content: "basic_auth_users:\n  admin: s3cret\n", err: `invalid bcrypt hash for user "admin"`},
+		{name: "missing_key", content: "tls_server_config:\n  cert_file: " + certFile + "\n", err: "needs cert_file and key_file"},
+		{name: "unreadable_cert", content: "tls_server_config:\n  cert_file: " + filepath.Join(dir, "missing.crt") + "\n  key_file: " + keyFile + "\n", err: "failed loading server certificate"},
+		{name: "invalid_client_auth_type", content: tlsConfig + "  client_auth_type: Always\n", err: `invalid client_auth_type "Always"`},
+		{name: "invalid_min_version", content: tlsConfig + "  min_version: SSL3\n", err: `invalid min_version "SSL3"`},
+		{name: "verify_without_ca", content: tlsConfig + "  client_auth_type: VerifyClientCertIfGiven\n", err: "needs client_ca_file"},
+		{name: "ca_without_pem", content: tlsConfig + "  client_ca_file: " + keyFile + "\n", err: "no PEM certificates found in client CA file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := loadWebConfig(writeWebConfig(t, t.TempDir(), tc.content))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want one containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.tlsEnabled() != tc.tls {
+				t.Errorf("TLS enabled = %v, want %v", w.tlsEnabled(), tc.tls)
+			}
+			if len(w.users) != tc.users {
+				t.Errorf("%d basic auth users, want %d", len(w.users), tc.users)
+			}
+		})
+	}
+	if _, err := loadWebConfig(filepath.Join(dir, "missing.yml")); err == nil {
+		t.Error("loading a missing file succeeded")
+	}
+}
+
+func TestWebConfigBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	w, err := loadWebConfig(writeWebConfig(t, dir, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := w.handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		name           string
+		users          bool
+		user, password string
+		status         int
+	}{
+		{name: "no_users", status: http.StatusOK},
+		{name: "no_credentials", users: true, status: http.StatusUnauthorized},
+		{name: "valid", users: true, user: "admin", password: "s3cret", status: http.StatusOK},
+		{name: "wrong_password", users: true, user: "admin", password: "wrong", status: http.StatusUnauthorized},
+		{name: "unknown_user", users: true, user: "guest", password: "s3cret", status: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			content := ""
+			if tc.users {
+				content = "basic_auth_users:\n  admin: " + string(hash) + "\n"
+			}
+			writeWebConfig(t, dir, content)
+			if err := w.reload(); err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tc.user != "" {
+				request.SetBasicAuth(tc.user, tc.password)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != tc.status {
+				t.Errorf("status = %d, want %d", recorder.Code, tc.status)
+			}
+			if challenge := recorder.Header().Get("WWW-Authenticate"); (challenge != "") != (tc.status == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q with status %d", challenge, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestWebConfigTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	firstCert, firstKey, first := writeCert(t, dir, "first")
+	secondCert, secondKey, second := writeCert(t, dir, "second")
+	w, err := loadWebConfig(writeWebConfig(t, dir, "tls_server_config:\n  cert_file: "+firstCert+"\n  key_file: "+firstKey+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(w.handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})))
+	server.TLS = w.serverTLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	// servedCert connects to the server and returns its certificate
+	servedCert := func() *x509.Certificate {
+		t.Helper()
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+	if got := servedCert(); !got.Equal(first) {
+		t.Errorf("served %s, want first", got.Subject.CommonName)
+	}
+	writeWebConfig(t, dir, "tls_server_config:\n  cert_file: "+secondCert+"\n  key_file: "+secondKey+"\n")
+	if err := w.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedCert(); !got.Equal(second) {
+		t.Errorf("served %s after reload, want second", got.Subject.CommonName)
+	}
+
+	// a reload cannot turn TLS off and keeps the settings on failure
+	writeWebConfig(t, dir, "")
+	if err := w.reload(); err == nil || !strings.Contains(err.Error(), "cannot be added or removed") {
+		t.Errorf("reload without TLS error = %v", err)
+	}
+	writeWebConfig(t, dir, "tls_server_config:\n  cert_file: "+secondCert+"\n")
+	if err := w.reload(); err == nil {
+		t.Error("reload without key_file succeeded")
+	}
+	if got := servedCert(); !got.Equal(second) {
+		t.Errorf("served %s after failed reloads, want second", got.Subject.CommonName)
+	}
+}
@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// benchPayload is the /stats payload of the demo server
+const benchPayload = `{"http200Requestcounter": 1234, "http500Requestcounter": 56}`
+
+// drain collects c once, discarding the metrics
+func drain(c prometheus.Collector) {
+	ch := make(chan prometheus.Metric, 64)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	for range ch {
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	b.Run("static", func(b *testing.B) {
+		c, err := NewCollector("http://bench.invalid", WithFetcher(defaultStatsPath, StaticFetcher{Body: []byte(benchPayload)}))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			drain(c)
+		}
+	})
+	b.Run("http", func(b *testing.B) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(benchPayload))
+		}))
+		defer server.Close()
+		c, err := NewCollector(server.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			drain(c)
+		}
+	})
+}
+
+func BenchmarkFetchAndDecode(b *testing.B) {
+	c, err := NewCollector("http://bench.invalid", WithFetcher(defaultStatsPath, StaticFetcher{Body: []byte(benchPayload)}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.fetchStatsEndpoint(context.Background(), defaultStatsPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	body := []byte(benchPayload)
+	wanted := map[string]bool{DefaultField200: true, DefaultField500: true}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := (jsonParser{}).Parse(body, wanted); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,1370 @@
+// Package collector exports the stats of an HTTP server as Prometheus metrics
+package collector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// DefaultMaxBodyBytes limits the size of target responses
+	DefaultMaxBodyBytes = 4 << 20
+	// defaultStatsPath is fetched for metrics that do not name a path
+	defaultStatsPath = "/stats"
+	// maxConcurrentFetches bounds the paths of the target fetched at once
+	maxConcurrentFetches = 4
+)
+
+// instanceIDLabel carries the instance ID on the collector's own metrics
+const instanceIDLabel = "instance_id"
+
+// metric names of the collector's own metrics
+var (
+	upName               = prometheus.BuildFQName("httpserver", "", "up")
+	endpointUpName       = prometheus.BuildFQName("httpserver", "", "endpoint_up")
+	uptimeName           = prometheus.BuildFQName("httpserver", "exporter", "uptime_seconds")
+	tlsCertExpiryName    = "exporter_target_tls_cert_expiry_timestamp_seconds"
+	tlsCertNotBeforeName = "exporter_target_tls_cert_not_before_timestamp_seconds"
+	unknownFieldsName    = "exporter_unknown_fields_total"
+	invalidValuesName    = "exporter_invalid_values_total"
+	malformedRowsName    = "exporter_malformed_rows_total"
+	fileMtimeName        = "httpserver_stats_file_mtime_seconds"
+	responseAgeName      = "httpserver_stats_response_age_seconds"
+	requestDurationName  = "exporter_target_request_duration_seconds"
+	responseStatusName   = "exporter_target_http_status"
+	targetStatusName     = "httpserver_target_response_status_total"
+	scrapeErrorsName     = "httpserver_scrape_errors_total"
+	circuitOpenName      = "httpserver_target_circuit_open"
+	failuresName         = "httpserver_consecutive_scrape_failures"
+	connsReusedName      = "exporter_target_connections_reused_total"
+	dnsLookupsName       = "exporter_target_dns_lookups_total"
+	sinceSuccessName     = "httpserver_seconds_since_last_success"
+	unhealthyName        = "httpserver_target_unhealthy"
+	panicsName           = "httpserver_collector_panics_total"
+)
+
+// maxExactFloat is the largest integer a float64 holds without rounding
+const maxExactFloat = 1 << 53
+
+// null handling policies for metric values
+const (
+	onNullSkip = "skip"
+	onNullZero = "zero"
+	onNullNaN  = "nan"
+)
+
+type exportedMetric struct {
+	name        string
+	help        string
+	constLabels prometheus.Labels
+	desc        *prometheus.Desc
+	// rateDesc is the per-second gauge derived from a counter
+	rateDesc *prometheus.Desc
+	// path of the target endpoint serving the stats JSON
+	path string
+	// field is the top-level key of the stats JSON holding the value
+	field   string
+	valType prometheus.ValueType
+	// onNull overrides the global null policy when set
+	onNull string
+	// clampMin raises values below it to the minimum when set
+	clampMin *float64
+}
+type exportedMetrics []exportedMetric
+
+// eval looks up the metric's field in the decoded stats
+func (m exportedMetric) eval(stats map[string]statValue) statValue {
+	return stats[m.field]
+}
+
+// default JSON keys of the demo server's stats
+const (
+	DefaultField200 = "http200Requestcounter"
+	DefaultField500 = "http500Requestcounter"
+)
+
+// defaultMetrics maps the demo server's stats, reading the request counters from the given keys
+func defaultMetrics(field200, field500 string) exportedMetrics {
+	return exportedMetrics{
+		{
+			name:        prometheus.BuildFQName("http", "request", "200counter"),
+			help:        "Total number of HTTP 200 responses served by the target.",
+			constLabels: prometheus.Labels{"counter": "twohundred"},
+			path:        defaultStatsPath,
+			field:       field200,
+			valType:     prometheus.CounterValue,
+		},
+		{
+			name:        prometheus.BuildFQName("http", "request", "500counter"),
+			help:        "Total number of HTTP 500 responses served by the target.",
+			constLabels: prometheus.Labels{"counter": "fivehundred"},
+			path:        defaultStatsPath,
+			field:       field500,
+			valType:     prometheus.CounterValue,
+		},
+		{
+			name:    prometheus.BuildFQName("httpserver", "", "rate_limited_total"),
+			help:    "Total number of requests refused by the target's rate limiter.",
+			path:    defaultStatsPath,
+			field:   "httpRateLimitedcounter",
+			valType: prometheus.CounterValue,
+		},
+	}
+}
+
+// selfMetric is a metric the collector keeps about its own operation
+type selfMetric struct {
+	name      string
+	collector prometheus.Collector
+}
+
+type Collector struct {
+	client     *http.Client
+	httpServer *url.URL
+	// trace counts DNS lookups and reused connections of target requests
+	trace *httptrace.ClientTrace
+	// namespace and constLabels apply to every metric of the collector
+	namespace   string
+	constLabels prometheus.Labels
+	// statsPath is fetched for metrics that do not name a path
+	statsPath  string
+	up         *prometheus.Desc
+	endpointUp *prometheus.Desc
+	uptime     *prometheus.Desc
+	// upMetrics and endpointUpMetrics by path are built once, indexed by
+	// their value
+	upMetrics         [2]prometheus.Metric
+	endpointUpMetrics map[string][2]prometheus.Metric
+	sinceSuccess      *prometheus.Desc
+	unhealthy         *prometheus.Desc
+	tlsCertExpiry     *prometheus.Desc
+	tlsCertNotBefore  *prometheus.Desc
+	metrics           exportedMetrics
+	// paths of the target the metrics are read from
+	paths []string
+	// requests of paths that are not fetched with a plain GET
+	requests map[string]endpointRequest
+	// fetchers source the stats of each path
+	fetchers map[string]Fetcher
+	// upRequiresAllPaths reports the target down when any path fails
+	upRequiresAllPaths bool
+	// upMeansReachable keeps paths answering with a bad status up
+	upMeansReachable bool
+	// keepLastScrapes reports the lastScrapes of failed paths again
+	keepLastScrapes bool
+	lastScrapesMu   sync.Mutex
+	lastScrapes     map[string]pathScrape
+	// traceparent is sent with target requests when set
+	traceparent bool
+	strictJSON  bool
+	requireJSON bool
+	onNull      string
+	// knownFields by path
+	knownFields   map[string]map[string]bool
+	unknownFields prometheus.Counter
+	invalidValues prometheus.Counter
+	malformedRows prometheus.Counter
+	panics        prometheus.Counter
+	// client-side observations of the target
+	requestDuration *prometheus.HistogramVec
+	responseStatus  *prometheus.CounterVec
+	targetStatus    *prometheus.CounterVec
+	// tlsState of the last response, nil for plain HTTP targets
+	tlsState *tls.ConnectionState
+	tlsMu    sync.Mutex
+	// headers and userAgent are added to every target request
+	headers   http.Header
+	userAgent string
+	// credentials, read from their files on every scrape
+	bearerTokenFile       string
+	basicAuthUser         string
+	basicAuthPasswordFile string
+	scrapeErrors          *prometheus.CounterVec
+	// disableGzip stops requesting gzip compressed responses
+	disableGzip bool
+	selfMetrics []selfMetric
+	// include is the metric name allowlist
+	include map[string]bool
+	// rates follows the counters to notice decreases and derive per-second
+	// gauges when emitRates is set
+	rates     *rateTracker
+	emitRates bool
+	// circuit breaker skipping fetches after failureThreshold consecutive failures
+	failureThreshold    int
+	failureCooldown     time.Duration
+	breakerMu           sync.Mutex
+	consecutiveFailures int
+	// lastErr is the failure of the last scrape, nil after a success
+	lastErr          error
+	openUntil        time.Time
+	circuitOpen      prometheus.Gauge
+	consecutiveGauge prometheus.Gauge
+	// lastSuccess of a scrape of the target, zero before the first, guarded by breakerMu
+	lastSuccess       time.Time
+	connectionsReused prometheus.Counter
+	dnsLookups        prometheus.Counter
+	// maxBodyBytes limits responses, files and command output
+	maxBodyBytes int64
+	// statsFile is read for the default path of file:// targets
+	statsFile       string
+	statsFileMaxAge time.Duration
+	fileMtime       *prometheus.GaugeVec
+	// responseAge of the last response of a path carrying Date or Age
+	responseAge *prometheus.GaugeVec
+	// startTime of the exporter process
+	startTime time.Time
+	logger    Logger
+	// precisionLossOnce limits the warning about values too large for float64
+	precisionLossOnce sync.Once
+}
+
+// NewCollector creates a collector exporting the stats of the target at
+// target, an http(s), unix or file URL
+func NewCollector(target string, opts ...Option) (*Collector, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target url: %w", err)
+	}
+	o := options{
+		client:       &http.Client{},
+		statsPath:    defaultStatsPath,
+		onNull:       onNullZero,
+		maxBodyBytes: DefaultMaxBodyBytes,
+		startTime:    time.Now(),
+		logger:       nopLogger{},
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if o.bearerTokenFile != "" && o.basicAuthUser != "" {
+		return nil, errors.New("bearer token and basic auth are mutually exclusive")
+	}
+	// selfLabels tell the own metrics of collector instances apart
+	var instanceLabels prometheus.Labels
+	selfLabels := o.constLabels
+	if o.instanceID != "" {
+		if _, ok := o.constLabels[instanceIDLabel]; ok {
+			return nil, fmt.Errorf("const label %q clashes with the instance ID", instanceIDLabel)
+		}
+		instanceLabels = prometheus.Labels{instanceIDLabel: o.instanceID}
+		selfLabels = prometheus.Labels{instanceIDLabel: o.instanceID}
+		for k, v := range o.constLabels {
+			selfLabels[k] = v
+		}
+	}
+
+	requestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   o.namespace,
+		Name:                        requestDurationName,
+		Help:                        "Duration of requests to the target.",
+		ConstLabels:                 selfLabels,
+		Buckets:                     prometheus.DefBuckets,
+		NativeHistogramBucketFactor: 1.1,
+	}, nil)
+	responseStatus := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   o.namespace,
+		Name:        responseStatusName,
+		Help:        "Number of responses received from the target by HTTP status code.",
+		ConstLabels: selfLabels,
+	}, []string{"code"})
+	// instrument a copy so the caller's client is left untouched
+	instrumented := *o.client
+	if o.timeout > 0 {
+		instrumented.Timeout = o.timeout
+	}
+	transport := instrumented.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	exemplar := promhttp.WithExemplarFromContext(traceExemplar)
+	instrumented.Transport = promhttp.InstrumentRoundTripperCounter(responseStatus,
+		promhttp.InstrumentRoundTripperDuration(requestDuration, transport, exemplar), exemplar)
+
+	e := &Collector{
+		namespace:             o.namespace,
+		constLabels:           o.constLabels,
+		statsPath:             o.statsPath,
+		startTime:             o.startTime,
+		maxBodyBytes:          o.maxBodyBytes,
+		logger:                o.logger,
+		client:                &instrumented,
+		httpServer:            targetURL,
+		strictJSON:            o.strictJSON,
+		requireJSON:           o.requireJSON,
+		onNull:                o.onNull,
+		headers:               o.headers,
+		userAgent:             o.userAgent,
+		disableGzip:           o.disableGzip,
+		upRequiresAllPaths:    o.upRequiresAllPaths,
+		upMeansReachable:      o.upMeansReachable,
+		keepLastScrapes:       o.keepLastScrapes,
+		lastScrapes:           map[string]pathScrape{},
+		traceparent:           o.traceparent,
+		failureThreshold:      o.failureThreshold,
+		failureCooldown:       o.failureCooldown,
+		bearerTokenFile:       o.bearerTokenFile,
+		basicAuthUser:         o.basicAuthUser,
+		basicAuthPasswordFile: o.basicAuthPasswordFile,
+		requestDuration:       requestDuration,
+		responseStatus:        responseStatus,
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        scrapeErrorsName,
+			Help:        "Number of failed scrapes of the target by reason.",
+			ConstLabels: selfLabels,
+		}, []string{"reason"}),
+		targetStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        targetStatusName,
+			Help:        "Number of /stats responses from the target by HTTP status code.",
+			ConstLabels: selfLabels,
+		}, []string{"code"}),
+		unknownFields: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        unknownFieldsName,
+			Help:        "Number of top-level fields in the target's stats that are not mapped to a metric.",
+			ConstLabels: selfLabels,
+		}),
+		connectionsReused: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        connsReusedName,
+			Help:        "Number of target requests sent over a reused connection.",
+			ConstLabels: selfLabels,
+		}),
+		dnsLookups: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        dnsLookupsName,
+			Help:        "Number of DNS lookups of the target host.",
+			ConstLabels: selfLabels,
+		}),
+		circuitOpen: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   o.namespace,
+			Name:        circuitOpenName,
+			Help:        "Whether fetches of the target are suspended after repeated failures.",
+			ConstLabels: selfLabels,
+		}),
+		consecutiveGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   o.namespace,
+			Name:        failuresName,
+			Help:        "Number of consecutive failed fetches of the target, 0 after a successful one.",
+			ConstLabels: selfLabels,
+		}),
+		fileMtime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   o.namespace,
+			Name:        fileMtimeName,
+			Help:        "Modification time of the stats file read for a path as unix timestamp.",
+			ConstLabels: selfLabels,
+		}, []string{"path"}),
+		responseAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   o.namespace,
+			Name:        responseAgeName,
+			Help:        "Age of the last response for a path from its Date and Age headers, above 0 when served from a cache.",
+			ConstLabels: selfLabels,
+		}, []string{"path"}),
+		malformedRows: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        malformedRowsName,
+			Help:        "Number of malformed rows skipped in the target's CSV stats.",
+			ConstLabels: selfLabels,
+		}),
+		invalidValues: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        invalidValuesName,
+			Help:        "Number of target values dropped because they are invalid for their metric type.",
+			ConstLabels: selfLabels,
+		}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        panicsName,
+			Help:        "Number of panics recovered while collecting a metric, which is then reported as invalid.",
+			ConstLabels: selfLabels,
+		}),
+	}
+	e.trace = &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			e.dnsLookups.Inc()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				e.connectionsReused.Inc()
+			}
+		},
+	}
+	e.up = e.newDesc(upName, "Last query successful.", nil, instanceLabels)
+	e.endpointUp = e.newDesc(endpointUpName, "Last query of the target path successful.", []string{"path"}, instanceLabels)
+	e.uptime = e.newDesc(uptimeName, "Seconds since the exporter started.", nil, instanceLabels)
+	e.unhealthy = e.newDesc(unhealthyName, "Whether a path of the reachable target answered with a bad status.", nil, instanceLabels)
+	e.sinceSuccess = e.newDesc(sinceSuccessName, "Seconds since the last successful scrape of the target, absent before the first.", nil, instanceLabels)
+	e.tlsCertExpiry = e.newDesc(tlsCertExpiryName, "NotAfter of the target's leaf TLS certificate as unix timestamp.", []string{"serial", "subject_cn"}, instanceLabels)
+	e.tlsCertNotBefore = e.newDesc(tlsCertNotBeforeName, "NotBefore of the target's leaf TLS certificate as unix timestamp.", []string{"serial", "subject_cn"}, instanceLabels)
+	e.selfMetrics = []selfMetric{
+		{unknownFieldsName, e.unknownFields},
+		{invalidValuesName, e.invalidValues},
+		{malformedRowsName, e.malformedRows},
+		{panicsName, e.panics},
+		{fileMtimeName, e.fileMtime},
+		{responseAgeName, e.responseAge},
+		{requestDurationName, e.requestDuration},
+		{responseStatusName, e.responseStatus},
+		{targetStatusName, e.targetStatus},
+		{scrapeErrorsName, e.scrapeErrors},
+		{circuitOpenName, e.circuitOpen},
+		{failuresName, e.consecutiveGauge},
+		{connsReusedName, e.connectionsReused},
+		{dnsLookupsName, e.dnsLookups},
+	}
+	e.rates, e.emitRates = newRateTracker(), o.emitRates
+	if len(o.include) > 0 {
+		e.include = map[string]bool{}
+		for _, name := range o.include {
+			e.include[name] = true
+		}
+	}
+	mapping := o.mapping
+	if mapping == nil {
+		mapping = DefaultMapping("", "")
+	}
+	// descs are set on the copy, the mapping may be shared between collectors
+	e.setMetrics(append(exportedMetrics(nil), mapping.metrics...))
+	e.setEndpoints(mapping.requests)
+	if err := e.checkConstLabels(); err != nil {
+		return nil, err
+	}
+	if o.statsRequest != nil {
+		if err := e.setStatsRequest(*o.statsRequest); err != nil {
+			return nil, err
+		}
+	}
+	if e.httpServer.Scheme == "file" {
+		// only the default path is read from the file, others need a source of their own
+		e.statsFile, e.statsFileMaxAge = e.httpServer.Path, o.fileMaxAge
+		for _, path := range e.paths {
+			if _, ok := e.requests[path]; !ok && o.fetchers[path] == nil && path != e.statsPath {
+				return nil, fmt.Errorf("path %s has no file or command for file:// target", path)
+			}
+		}
+	}
+	e.fetchers = map[string]Fetcher{}
+	for _, path := range e.paths {
+		e.fetchers[path] = e.newFetcher(path)
+	}
+	for path, fetcher := range o.fetchers {
+		if e.fetchers[path] == nil {
+			return nil, fmt.Errorf("fetcher for path %s, which no metric or endpoint reads", path)
+		}
+		e.fetchers[path] = fetcher
+	}
+	e.upMetrics = [2]prometheus.Metric{
+		prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 0),
+		prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, 1),
+	}
+	e.endpointUpMetrics = map[string][2]prometheus.Metric{}
+	for _, path := range e.paths {
+		e.endpointUpMetrics[path] = [2]prometheus.Metric{
+			prometheus.MustNewConstMetric(e.endpointUp, prometheus.GaugeValue, 0, path),
+			prometheus.MustNewConstMetric(e.endpointUp, prometheus.GaugeValue, 1, path),
+		}
+	}
+	return e, nil
+}
+
+// MustNewCollector is like NewCollector but panics on invalid options
+func MustNewCollector(target string, opts ...Option) *Collector {
+	e, err := NewCollector(target, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// fqName prefixes name with the collector's namespace
+func (e *Collector) fqName(name string) string {
+	return prometheus.BuildFQName(e.namespace, "", name)
+}
+
+// newDesc builds a desc under the collector's namespace and const labels
+func (e *Collector) newDesc(name, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	labels := prometheus.Labels{}
+	for k, v := range e.constLabels {
+		labels[k] = v
+	}
+	for k, v := range constLabels {
+		labels[k] = v
+	}
+	return prometheus.NewDesc(e.fqName(name), help, variableLabels, labels)
+}
+
+// checkConstLabels rejects collector const labels that a metric already has
+func (e *Collector) checkConstLabels() error {
+	clash := func(labels ...string) error {
+		for _, label := range labels {
+			if _, ok := e.constLabels[label]; ok {
+				return fmt.Errorf("const label %q clashes with a label of the collector's metrics", label)
+			}
+		}
+		return nil
+	}
+	if err := clash("path", "serial", "subject_cn", "reason", "code", targetLabel); err != nil {
+		return err
+	}
+	for _, metric := range e.metrics {
+		for label := range metric.constLabels {
+			if err := clash(label); err != nil {
+				return err
+			}
+		}
+	}
+	for _, request := range e.requests {
+		if parser, ok := request.parser.(rowParser); ok {
+			if err := clash(parser.labelName()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setMetrics replaces the mapping of stats fields to metrics
+func (e *Collector) setMetrics(metrics exportedMetrics) {
+	e.knownFields = map[string]map[string]bool{}
+	e.paths = nil
+	for i := range metrics {
+		metric := &metrics[i]
+		if metric.path == defaultStatsPath {
+			metric.path = e.statsPath
+		}
+		metric.desc = e.newDesc(metric.name, metric.help, nil, metric.constLabels)
+		metric.rateDesc = e.newDesc(metric.name+rateSuffix, rateHelp(metric.name), nil, metric.constLabels)
+		if e.knownFields[metric.path] == nil {
+			e.knownFields[metric.path] = map[string]bool{}
+			e.paths = append(e.paths, metric.path)
+		}
+		e.knownFields[metric.path][metric.field] = true
+	}
+	e.metrics = metrics
+}
+
+// setStatsRequest sets how the stats path is requested, which the mapping
+// must leave to the default GET
+func (e *Collector) setStatsRequest(request endpointRequest) error {
+	if _, ok := e.requests[e.statsPath]; ok {
+		return fmt.Errorf("request of %s is set by both the metrics config and WithStatsRequest", e.statsPath)
+	}
+	if e.httpServer.Scheme == "file" {
+		return errors.New("WithStatsRequest does not apply to file:// targets")
+	}
+	// the mapping's requests may be shared between collectors
+	requests := make(map[string]endpointRequest, len(e.requests)+1)
+	for path, r := range e.requests {
+		requests[path] = r
+	}
+	requests[e.statsPath] = request
+	e.requests = requests
+	return nil
+}
+
+// setEndpoints sets how paths are requested, adding the paths of endpoints
+// that are proxied without a metric mapping
+func (e *Collector) setEndpoints(requests map[string]endpointRequest) {
+	e.requests = requests
+	if request, ok := requests[defaultStatsPath]; ok && e.statsPath != defaultStatsPath {
+		e.requests = map[string]endpointRequest{}
+		for path, r := range requests {
+			e.requests[path] = r
+		}
+		delete(e.requests, defaultStatsPath)
+		e.requests[e.statsPath] = request
+	}
+	for i := range e.metrics {
+		metric := &e.metrics[i]
+		// rows of labelled endpoints are told apart by a variable label
+		if parser, ok := e.requests[metric.path].parser.(rowParser); ok && parser.labelName() != "" {
+			metric.desc = e.newDesc(metric.name, metric.help, []string{parser.labelName()}, metric.constLabels)
+			metric.rateDesc = e.newDesc(metric.name+rateSuffix, rateHelp(metric.name), []string{parser.labelName()}, metric.constLabels)
+		}
+	}
+	for path := range e.requests {
+		if e.knownFields[path] == nil {
+			e.knownFields[path] = map[string]bool{}
+			e.paths = append(e.paths, path)
+		}
+	}
+	sort.Strings(e.paths)
+}
+
+// rateHelp
+func rateHelp(name string) string {
+	return fmt.Sprintf("Per-second rate of %s between the last two scrapes.", name)
+}
+
+// observeCounter records a counter series, warning when it decreased since
+// the last scrape, and returns its per-second rate
+func (e *Collector) observeCounter(metric exportedMetric, series string, value float64, at time.Time) (float64, bool) {
+	rate, ok, decreased := e.rates.observe(series, value, at)
+	if decreased {
+		e.logger.Warnf("Counter %s decreased to %v since the last scrape, the target may have restarted", metric.name, value)
+	}
+	return rate, ok
+}
+
+// emitsRate reports whether the per-second gauge of a metric is exposed
+func (e *Collector) emitsRate(metric exportedMetric) bool {
+	return e.emitRates && metric.valType == prometheus.CounterValue && e.included(metric.name+rateSuffix)
+}
+
+// included reports whether a metric passes the allowlist, an empty allowlist keeps all metrics
+func (e *Collector) included(name string) bool {
+	return len(e.include) == 0 || e.include[e.fqName(name)]
+}
+
+// Describe
+func (e *Collector) Describe(ch chan<- *prometheus.Desc) {
+	// register desc for up down metric
+	if e.included(upName) {
+		ch <- e.up
+	}
+	if e.included(endpointUpName) {
+		ch <- e.endpointUp
+	}
+	if e.included(uptimeName) {
+		ch <- e.uptime
+	}
+	if e.included(sinceSuccessName) {
+		ch <- e.sinceSuccess
+	}
+	if e.upMeansReachable && e.included(unhealthyName) {
+		ch <- e.unhealthy
+	}
+	if e.included(tlsCertExpiryName) {
+		ch <- e.tlsCertExpiry
+	}
+	if e.included(tlsCertNotBeforeName) {
+		ch <- e.tlsCertNotBefore
+	}
+	for _, m := range e.selfMetrics {
+		if e.included(m.name) {
+			m.collector.Describe(ch)
+		}
+	}
+	// register other descs
+	for _, metric := range e.metrics {
+		if e.included(metric.name) {
+			ch <- metric.desc
+		}
+		if e.emitsRate(metric) {
+			ch <- metric.rateDesc
+		}
+	}
+}
+
+// Collect
+func (e *Collector) Collect(ch chan<- prometheus.Metric) {
+	// self metrics go last so they account for this scrape
+	defer e.collectSelfMetrics(ch)
+	defer e.collectSinceSuccess(ch)
+	if e.included(uptimeName) {
+		ch <- prometheus.MustNewConstMetric(e.uptime, prometheus.GaugeValue, time.Since(e.startTime).Seconds())
+	}
+
+	if e.breakerOpen() {
+		if e.included(upName) {
+			ch <- e.upMetrics[0]
+		}
+		return
+	}
+	scrapes, errs := e.fetchPaths()
+	var err error
+	down, unhealthy := 0, 0
+	for _, path := range e.paths {
+		pathUp := 1
+		if pathErr := errs[path]; pathErr != nil {
+			var statusErr *ErrBadStatus
+			if e.upMeansReachable && errors.As(pathErr, &statusErr) {
+				unhealthy = 1
+			} else {
+				pathUp = 0
+				down++
+			}
+			e.scrapeErrors.WithLabelValues(errorReason(pathErr)).Inc()
+			if errors.Is(pathErr, ErrTimeout) {
+				// timeouts are usually transient
+				e.logger.Warnf("Failed getting %s endpoint of target: %v", path, pathErr)
+			} else {
+				e.logger.Errorf("Failed getting %s endpoint of target: %v", path, pathErr)
+			}
+			// bad statuses of reachable paths are no failed fetches
+			if pathUp == 0 && err == nil {
+				err = pathErr
+			}
+		}
+		if e.included(endpointUpName) {
+			ch <- e.endpointUpMetrics[path][pathUp]
+		}
+	}
+	// the target is up while any path answers, unless all are required
+	if err != nil && !e.upRequiresAllPaths && down < len(e.paths) {
+		err = nil
+	}
+	e.recordFetch(err)
+	if e.included(upName) {
+		targetUp := 1
+		if down > 0 && (e.upRequiresAllPaths || down == len(e.paths)) {
+			targetUp = 0
+		}
+		ch <- e.upMetrics[targetUp]
+	}
+	if e.upMeansReachable && e.included(unhealthyName) {
+		ch <- prometheus.MustNewConstMetric(e.unhealthy, prometheus.GaugeValue, float64(unhealthy))
+	}
+	if e.keepLastScrapes {
+		scrapes = e.withLastScrapes(scrapes)
+	}
+	if len(scrapes) == 0 {
+		return
+	}
+	e.tlsMu.Lock()
+	tlsState := e.tlsState
+	e.tlsMu.Unlock()
+	if tlsState != nil && len(tlsState.PeerCertificates) > 0 {
+		cert := tlsState.PeerCertificates[0]
+		serial, cn := cert.SerialNumber.String(), cert.Subject.CommonName
+		if e.included(tlsCertExpiryName) {
+			ch <- prometheus.MustNewConstMetric(e.tlsCertExpiry, prometheus.GaugeValue, float64(cert.NotAfter.Unix()), serial, cn)
+		}
+		if e.included(tlsCertNotBeforeName) {
+			ch <- prometheus.MustNewConstMetric(e.tlsCertNotBefore, prometheus.GaugeValue, float64(cert.NotBefore.Unix()), serial, cn)
+		}
+	}
+	now := time.Now()
+	for _, i := range e.metrics {
+		scrape, ok := scrapes[i.path]
+		if !ok {
+			// metrics of failed paths are left out
+			continue
+		}
+		e.collectMetric(ch, i, scrape, now)
+	}
+	for path, scrape := range scrapes {
+		if scrape.families != nil {
+			e.collectFamilies(ch, e.requests[path].namespace, scrape.families)
+		}
+	}
+}
+
+// withLastScrapes remembers scrapes and returns them along with the last
+// scrapes of the paths that failed
+func (e *Collector) withLastScrapes(scrapes map[string]pathScrape) map[string]pathScrape {
+	e.lastScrapesMu.Lock()
+	defer e.lastScrapesMu.Unlock()
+	for path, scrape := range scrapes {
+		e.lastScrapes[path] = scrape
+	}
+	all := make(map[string]pathScrape, len(e.lastScrapes))
+	for path, scrape := range e.lastScrapes {
+		all[path] = scrape
+	}
+	return all
+}
+
+// collectMetric emits the samples of metric from scrape. A panic is
+// recovered and reported as an invalid metric so the other metrics of the
+// scrape are still collected.
+func (e *Collector) collectMetric(ch chan<- prometheus.Metric, metric exportedMetric, scrape pathScrape, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.panics.Inc()
+			e.logger.Errorf("Recovered from panic collecting %s: %v", metric.name, r)
+			ch <- prometheus.NewInvalidMetric(metric.desc, fmt.Errorf("panic collecting %s: %v", metric.name, r))
+		}
+	}()
+	included, withRate := e.included(metric.name), e.emitsRate(metric)
+	if scrape.labelled {
+		for _, row := range scrape.rows {
+			value, ok := e.extractValue(metric, metric.eval(row.stats))
+			if !ok {
+				continue
+			}
+			if included {
+				ch <- prometheus.MustNewConstMetric(metric.desc, metric.valType, value, row.label)
+			}
+			if metric.valType != prometheus.CounterValue {
+				continue
+			}
+			if rate, ok := e.observeCounter(metric, metric.name+"\xff"+row.label, value, now); ok && withRate {
+				ch <- prometheus.MustNewConstMetric(metric.rateDesc, prometheus.GaugeValue, rate, row.label)
+			}
+		}
+		return
+	}
+	value, ok := e.extractValue(metric, metric.eval(scrape.stats))
+	if !ok {
+		return
+	}
+	if included {
+		ch <- prometheus.MustNewConstMetric(metric.desc, metric.valType, value)
+	}
+	if metric.valType != prometheus.CounterValue {
+		return
+	}
+	if rate, ok := e.observeCounter(metric, metric.name, value, now); ok && withRate {
+		ch <- prometheus.MustNewConstMetric(metric.rateDesc, prometheus.GaugeValue, rate)
+	}
+}
+
+// breakerOpen reports whether fetches are suspended
+func (e *Collector) breakerOpen() bool {
+	e.breakerMu.Lock()
+	defer e.breakerMu.Unlock()
+	return time.Now().Before(e.openUntil)
+}
+
+// recordFetch tracks consecutive failures and opens the breaker once the threshold is reached
+func (e *Collector) recordFetch(err error) {
+	e.breakerMu.Lock()
+	defer e.breakerMu.Unlock()
+	e.lastErr = err
+	if err == nil {
+		e.lastSuccess = time.Now()
+		e.consecutiveFailures = 0
+		e.consecutiveGauge.Set(0)
+		e.circuitOpen.Set(0)
+		return
+	}
+	e.consecutiveFailures++
+	e.consecutiveGauge.Set(float64(e.consecutiveFailures))
+	if e.failureThreshold > 0 && e.consecutiveFailures >= e.failureThreshold {
+		e.openUntil = time.Now().Add(e.failureCooldown)
+		e.circuitOpen.Set(1)
+		e.logger.Warnf("Target failed %d consecutive scrapes, suspending fetches for %s", e.consecutiveFailures, e.failureCooldown)
+	}
+}
+
+// Check fetches and decodes every path of the target once, outside of a
+// scrape, returning the first failure. Success marks the collector ready.
+func (e *Collector) Check(ctx context.Context) error {
+	for _, path := range e.paths {
+		if _, err := e.fetchStatsEndpoint(ctx, path); err != nil {
+			return fmt.Errorf("path %s: %w", path, err)
+		}
+	}
+	e.breakerMu.Lock()
+	e.lastSuccess = time.Now()
+	e.breakerMu.Unlock()
+	return nil
+}
+
+// Ready reports whether a scrape of the target has succeeded yet
+func (e *Collector) Ready() bool {
+	e.breakerMu.Lock()
+	defer e.breakerMu.Unlock()
+	return !e.lastSuccess.IsZero()
+}
+
+// TargetUp reports whether the last scrape of the target succeeded and
+// whether it has been scraped at all
+func (e *Collector) TargetUp() (up, scraped bool) {
+	e.breakerMu.Lock()
+	defer e.breakerMu.Unlock()
+	scraped = !e.lastSuccess.IsZero() || e.consecutiveFailures > 0
+	return scraped && e.consecutiveFailures == 0, scraped
+}
+
+// LastError returns why the last scrape of the target failed, nil when it
+// succeeded or before the first
+func (e *Collector) LastError() error {
+	e.breakerMu.Lock()
+	defer e.breakerMu.Unlock()
+	return e.lastErr
+}
+
+// collectSinceSuccess reports the age of the last successful scrape, leaving
+// it out before the first
+func (e *Collector) collectSinceSuccess(ch chan<- prometheus.Metric) {
+	if !e.included(sinceSuccessName) {
+		return
+	}
+	e.breakerMu.Lock()
+	lastSuccess := e.lastSuccess
+	e.breakerMu.Unlock()
+	if lastSuccess.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.sinceSuccess, prometheus.GaugeValue, time.Since(lastSuccess).Seconds())
+}
+
+// collectSelfMetrics
+func (e *Collector) collectSelfMetrics(ch chan<- prometheus.Metric) {
+	for _, m := range e.selfMetrics {
+		if e.included(m.name) {
+			m.collector.Collect(ch)
+		}
+	}
+}
+
+// extractValue applies the null and range policies of a metric to a target value,
+// reporting whether a sample should be emitted
+func (e *Collector) extractValue(metric exportedMetric, v statValue) (float64, bool) {
+	if v.null {
+		onNull := metric.onNull
+		if onNull == "" {
+			onNull = e.onNull
+		}
+		switch onNull {
+		case onNullSkip:
+			return 0, false
+		case onNullNaN:
+			return math.NaN(), true
+		default:
+			return 0, true
+		}
+	}
+	if v.losesPrecision() {
+		e.precisionLossOnce.Do(func() {
+			e.logger.Warnf("Counter value %s exceeds 2^53 and loses precision as float64", v.raw)
+		})
+	}
+	value := v.Float64()
+	if metric.clampMin != nil && value < *metric.clampMin {
+		value = *metric.clampMin
+	}
+	if metric.valType == prometheus.CounterValue && value < 0 {
+		e.invalidValues.Inc()
+		e.logger.Warnf("Skipping negative value %v for counter %s", value, metric.name)
+		return 0, false
+	}
+	return value, true
+}
+
+// pathScrape is the decoded response of one path, stats for json endpoints,
+// families for prometheus ones and rows for labelled csv ones
+type pathScrape struct {
+	stats    map[string]statValue
+	families []*dto.MetricFamily
+	// rows of labelled csv endpoints
+	rows     []statRow
+	labelled bool
+}
+
+// fetchPaths fetches all paths of the target concurrently, returning the
+// stats of the paths that succeeded and the errors of those that failed
+func (e *Collector) fetchPaths() (map[string]pathScrape, map[string]error) {
+	if len(e.paths) == 1 {
+		// the common single path target needs no goroutine
+		path := e.paths[0]
+		scrape, err := e.fetchStatsEndpoint(context.Background(), path)
+		if err != nil {
+			return nil, map[string]error{path: err}
+		}
+		return map[string]pathScrape{path: scrape}, nil
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		scrapes = map[string]pathScrape{}
+		errs    = map[string]error{}
+		slots   = make(chan struct{}, maxConcurrentFetches)
+	)
+	for _, path := range e.paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			scrape, err := e.fetchStatsEndpoint(context.Background(), path)
+			<-slots
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[path] = err
+				return
+			}
+			scrapes[path] = scrape
+		}(path)
+	}
+	wg.Wait()
+	return scrapes, errs
+}
+
+// fetchStatsEndpoint fetches path with its Fetcher and decodes the stats
+func (e *Collector) fetchStatsEndpoint(ctx context.Context, path string) (pathScrape, error) {
+	scrape, _, err := e.fetchStats(ctx, path)
+	return scrape, err
+}
+
+// fetchStats is fetchStatsEndpoint also returning how path was fetched. A
+// Fetcher or Parser that panics fails the fetch instead of the process,
+// since paths are fetched in their own goroutines.
+func (e *Collector) fetchStats(ctx context.Context, path string) (scrape pathScrape, info FetchInfo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.panics.Inc()
+			e.logger.Errorf("Recovered from panic fetching %s: %v", path, r)
+			scrape, err = pathScrape{}, &scrapeError{reason: "panic", err: fmt.Errorf("panic fetching %s: %v", path, r)}
+		}
+	}()
+	body, info, err := e.fetchers[path].Fetch(ctx)
+	if info.StatusCode != 0 {
+		// set on every response, including those over reused connections
+		e.tlsMu.Lock()
+		e.tlsState = info.TLS
+		e.tlsMu.Unlock()
+	}
+	if err != nil {
+		return pathScrape{}, info, err
+	}
+	scrape, err = e.decodeStats(path, e.endpoint(path), body)
+	return scrape, info, err
+}
+
+// endpoint returns how path is requested, a GET of JSON unless configured
+func (e *Collector) endpoint(path string) endpointRequest {
+	endpoint, ok := e.requests[path]
+	if !ok {
+		endpoint.method, endpoint.format, endpoint.parser = http.MethodGet, formatJSON, jsonParser{}
+		if path == e.statsPath {
+			endpoint.file, endpoint.maxAge = e.statsFile, e.statsFileMaxAge
+		}
+	}
+	return endpoint
+}
+
+// readBody reads a response of at most maxBodyBytes from r
+func (e *Collector) readBody(r io.Reader) ([]byte, error) {
+	body, err := readLimited(r, e.maxBodyBytes)
+	if errors.Is(err, ErrBodyTooLarge) {
+		// a truncated body is never parsed
+		return nil, newFetchError(err)
+	}
+	var scrapeErr *scrapeError
+	if errors.As(err, &scrapeErr) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &scrapeError{reason: "read", err: newFetchError(err)}
+	}
+	return body, nil
+}
+
+// maxLoggedBody is the most of an unparsable body logged
+const maxLoggedBody = 512
+
+// truncateBody returns at most maxLoggedBody bytes of body, marking the cut
+func truncateBody(body []byte) string {
+	if len(body) <= maxLoggedBody {
+		return string(body)
+	}
+	return string(body[:maxLoggedBody]) + "..."
+}
+
+// pathURL appends path to the path of the target URL without doubling the
+// slash between them. The query of the target URL is kept, parameters of
+// path replacing those of the same name, and the fragment dropped.
+func (e *Collector) pathURL(path string) string {
+	u := *e.httpServer
+	u.Fragment, u.RawFragment = "", ""
+	query := ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(u.RawPath, "/") + path
+	}
+	if query != "" {
+		values := u.Query()
+		// keeps the well-formed parameters of a malformed query
+		extra, _ := url.ParseQuery(query)
+		for name, v := range extra {
+			values[name] = v
+		}
+		u.RawQuery = values.Encode()
+	}
+	return u.String()
+}
+
+// fetchHTTP requests path from the target and returns the response body,
+// which the caller must close
+func (e *Collector) fetchHTTP(ctx context.Context, path string, endpoint endpointRequest) (io.ReadCloser, FetchInfo, error) {
+	var info FetchInfo
+	var requestBody io.Reader
+	if endpoint.body != nil {
+		requestBody = bytes.NewReader(endpoint.body)
+	}
+	request, err := http.NewRequestWithContext(ctx, endpoint.method, e.pathURL(path), requestBody)
+	if err != nil {
+		return nil, info, err
+	}
+	if endpoint.contentType != "" {
+		request.Header.Set("Content-Type", endpoint.contentType)
+	}
+	for name, values := range e.headers {
+		if name == "Host" {
+			if len(values) > 0 {
+				request.Host = values[0]
+			}
+			continue
+		}
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+	if e.userAgent != "" {
+		request.Header.Set("User-Agent", e.userAgent)
+	}
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(), e.trace))
+	if e.traceparent {
+		request = startTrace(request)
+	}
+	switch endpoint.format {
+	case formatPrometheus:
+		request.Header.Set("Accept", string(expfmt.FmtText))
+	case formatXML:
+		request.Header.Set("Accept", "application/xml")
+	}
+	if e.disableGzip {
+		// also keeps the transport from negotiating gzip on its own
+		request.Header.Set("Accept-Encoding", "identity")
+	} else {
+		request.Header.Set("Accept-Encoding", "gzip")
+	}
+	if err := e.setAuthorization(request); err != nil {
+		return nil, info, &scrapeError{reason: "auth", err: err}
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		e.logger.Debugf("Could not fetch %s endpoint of target: %v", path, e.httpServer.Redacted())
+		if errors.Is(err, ErrRedirectRefused) {
+			return nil, info, &scrapeError{reason: "redirect", err: newFetchError(err)}
+		}
+		return nil, info, &scrapeError{reason: "fetch", err: newFetchError(err)}
+	}
+	info.StatusCode, info.TLS = response.StatusCode, response.TLS
+
+	// closed here unless handed to the caller
+	body := response.Body
+	defer func() {
+		if body == nil {
+			response.Body.Close()
+		}
+	}()
+	e.targetStatus.WithLabelValues(strconv.Itoa(response.StatusCode)).Inc()
+
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		body = nil
+		return nil, info, &scrapeError{reason: "auth", err: &ErrBadStatus{Code: response.StatusCode}}
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body = nil
+		return nil, info, &scrapeError{reason: "status", err: &ErrBadStatus{Code: response.StatusCode}}
+	}
+
+	if age, ok := responseAge(response.Header, time.Now()); ok {
+		e.responseAge.WithLabelValues(path).Set(age)
+	} else {
+		e.responseAge.DeleteLabelValues(path)
+	}
+
+	if e.requireJSON && endpoint.format == formatJSON {
+		contentType := response.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" {
+			body = nil
+			return nil, info, &scrapeError{reason: "content_type", err: &ErrParse{Err: fmt.Errorf("unexpected Content-Type %q, expected application/json", contentType)}}
+		}
+	}
+
+	// setting Accept-Encoding ourselves disables the transport's transparent decompression
+	compressed := strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip")
+	// a declared length fails early, chunked responses (-1) are limited while reading
+	if !compressed && response.ContentLength > e.maxBodyBytes {
+		body = nil
+		return nil, info, newFetchError(fmt.Errorf("%w of %d bytes, Content-Length is %d", ErrBodyTooLarge, e.maxBodyBytes, response.ContentLength))
+	}
+	if compressed {
+		gzipReader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			body = nil
+			return nil, info, &scrapeError{reason: "decode", err: &ErrParse{Err: fmt.Errorf("invalid gzip response: %w", err)}}
+		}
+		return &gzipBody{Reader: gzipReader, body: response.Body}, info, nil
+	}
+	return body, info, nil
+}
+
+// responseAge returns the age of a response received at now as a cache
+// computes it, the larger of its Age header and the time since its Date
+// header, false when it has neither or both are malformed
+func responseAge(header http.Header, now time.Time) (float64, bool) {
+	age, ok := 0.0, false
+	// Age is a non-negative integer of seconds
+	if seconds, err := strconv.ParseUint(header.Get("Age"), 10, 63); err == nil {
+		age, ok = float64(seconds), true
+	}
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		// Date has a resolution of seconds
+		if apparent := now.Truncate(time.Second).Sub(date).Seconds(); apparent > age {
+			age = apparent
+		}
+		ok = true
+	}
+	return age, ok
+}
+
+// gzipBody decompresses a response body, closing both on Close
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+// Read reports corrupt compressed data as a decode error rather than a
+// transport problem
+func (g *gzipBody) Read(p []byte) (int, error) {
+	n, err := g.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = &scrapeError{reason: "decode", err: &ErrParse{Err: fmt.Errorf("invalid gzip response: %w", err)}}
+	}
+	return n, err
+}
+
+func (g *gzipBody) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// bufferPool holds the buffers bodies are read into, reused across scrapes
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// readLimited reads r to the end, failing with ErrBodyTooLarge beyond maxBytes
+func readLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+	if _, err := buf.ReadFrom(io.LimitReader(r, maxBytes+1)); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) > maxBytes {
+		return nil, fmt.Errorf("%w of %d bytes", ErrBodyTooLarge, maxBytes)
+	}
+	// the buffer goes back to the pool, the body is kept by the caller
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// decodeStats decodes a response in the endpoint's format
+func (e *Collector) decodeStats(path string, endpoint endpointRequest, bodyBytes []byte) (pathScrape, error) {
+	if endpoint.format == formatPrometheus {
+		families, err := parseFamilies(bodyBytes)
+		if err != nil {
+			return pathScrape{}, &scrapeError{reason: "decode", err: &ErrParse{Err: err}}
+		}
+		return pathScrape{families: families}, nil
+	}
+	var (
+		scrape  pathScrape
+		unknown []string
+		err     error
+	)
+	if parser, ok := endpoint.parser.(rowParser); ok {
+		var (
+			rows    []statRow
+			skipped []error
+		)
+		rows, unknown, skipped, err = parser.ParseRows(bodyBytes, e.knownFields[path])
+		for _, rowErr := range skipped {
+			e.logger.Warnf("Skipping malformed row of %s: %v", path, rowErr)
+		}
+		e.malformedRows.Add(float64(len(skipped)))
+		switch {
+		case parser.labelName() != "":
+			scrape.rows, scrape.labelled = rows, true
+		case len(rows) > 0:
+			// unlabelled rows are successive readings, the last is current
+			scrape.stats = rows[len(rows)-1].stats
+		default:
+			scrape.stats = map[string]statValue{}
+		}
+	} else {
+		scrape.stats, unknown, err = endpoint.parser.Parse(bodyBytes, e.knownFields[path])
+	}
+	if err != nil {
+		// the error itself is logged by Collect
+		e.logger.Debugf("Unparsable %s response of %s: %q", endpoint.format, path, truncateBody(bodyBytes))
+		return pathScrape{}, &scrapeError{reason: "decode", err: &ErrParse{Err: err}}
+	}
+	e.reportUnknownFields(path, unknown)
+	if e.strictJSON && len(unknown) > 0 {
+		return pathScrape{}, &scrapeError{reason: "decode", err: &ErrParse{Err: fmt.Errorf("unknown fields in stats: %s", strings.Join(unknown, ", "))}}
+	}
+	return scrape, nil
+}
+
+// setAuthorization sets the Authorization header from the configured credentials,
+// re-reading the secret files on every scrape so they can be rotated
+func (e *Collector) setAuthorization(request *http.Request) error {
+	switch {
+	case e.bearerTokenFile != "":
+		token, err := os.ReadFile(e.bearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed reading bearer token file: %w", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	case e.basicAuthUser != "":
+		var password []byte
+		if e.basicAuthPasswordFile != "" {
+			var err error
+			password, err = os.ReadFile(e.basicAuthPasswordFile)
+			if err != nil {
+				return fmt.Errorf("failed reading basic auth password file: %w", err)
+			}
+		}
+		request.SetBasicAuth(e.basicAuthUser, strings.TrimSpace(string(password)))
+	}
+	return nil
+}
+
+// reportUnknownFields counts and logs top-level fields of a response that are not mapped
+func (e *Collector) reportUnknownFields(path string, unknown []string) {
+	if len(unknown) == 0 {
+		return
+	}
+	sort.Strings(unknown)
+	e.unknownFields.Add(float64(len(unknown)))
+	e.logger.Debugf("Unmapped fields in %s stats of target: %s", path, strings.Join(unknown, ", "))
+}
+
+// ValidHeaderName reports whether name is a valid HTTP header field name token
+func ValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
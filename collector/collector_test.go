@@ -0,0 +1,1476 @@
+package collector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata.")
+
+// goldenMetrics are compared with the golden files, the others vary between
+// runs like durations and uptime
+var goldenMetrics = []string{
+	"http_request_200counter",
+	"http_request_500counter",
+	"httpserver_up",
+	"httpserver_endpoint_up",
+	"httpserver_consecutive_scrape_failures",
+	"httpserver_scrape_errors_total",
+	"exporter_unknown_fields_total",
+	"exporter_invalid_values_total",
+}
+
+// statsServer serves body with status on every path
+func statsServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCollectGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		// down closes the server before the scrape
+		down bool
+	}{
+		{name: "success", status: http.StatusOK, body: `{"http200Requestcounter": 5, "http500Requestcounter": 1}`},
+		{name: "target_down", down: true},
+		{name: "bad_status", status: http.StatusInternalServerError, body: `{}`},
+		{name: "malformed_json", status: http.StatusOK, body: `{"http200Requestcounter": 5,`},
+		{name: "partial_fields", status: http.StatusOK, body: `{"http200Requestcounter": 7, "uptime": 3}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := statsServer(t, tc.status, tc.body)
+			if tc.down {
+				server.Close()
+			}
+			c, err := NewCollector(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tc.name+".prom")
+			if *update {
+				writeGolden(t, c, golden)
+				return
+			}
+			expected, err := os.Open(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer expected.Close()
+			if err := testutil.CollectAndCompare(c, expected, goldenMetrics...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// writeGolden writes the golden metrics of one collection of c to file
+func writeGolden(t *testing.T, c prometheus.Collector, file string) {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	for _, family := range families {
+		for _, name := range goldenMetrics {
+			if family.GetName() == name {
+				if _, err := expfmt.MetricFamilyToText(&sb, family); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if err := os.WriteFile(file, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectLint(t *testing.T) {
+	server := statsServer(t, http.StatusOK, `{"http200Requestcounter": 5, "http500Requestcounter": 1}`)
+	c, err := NewCollector(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := testutil.CollectAndLint(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// names of the documented output that predate the exporter's conventions
+	known := map[string]bool{
+		"http_request_200counter":     true,
+		"http_request_500counter":     true,
+		"exporter_target_http_status": true,
+	}
+	for _, problem := range problems {
+		if !known[problem.Metric] {
+			t.Errorf("%s: %s", problem.Metric, problem.Text)
+		}
+	}
+}
+
+func TestFailedFetchOmitsCounters(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fail func(w http.ResponseWriter)
+	}{
+		{"bad_status", func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }},
+		{"malformed_json", func(w http.ResponseWriter) { w.Write([]byte(`{"http200Requestcounter":`)) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var failing int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&failing) == 1 {
+					tc.fail(w)
+					return
+				}
+				w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := testutil.CollectAndCount(c, "http_request_200counter", "http_request_500counter"); n != 2 {
+				t.Fatalf("healthy target exposes %d counters, want 2", n)
+			}
+			// the last known values must not be reported once the target fails
+			atomic.StoreInt32(&failing, 1)
+			if n := testutil.CollectAndCount(c, "http_request_200counter", "http_request_500counter"); n != 0 {
+				t.Errorf("failing target exposes %d counters, want none", n)
+			}
+			expected := "# HELP httpserver_up Last query successful.\n# TYPE httpserver_up gauge\nhttpserver_up 0\n"
+			if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "httpserver_up"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestStaleOnFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		stale bool
+		// counters exposed while the target fails
+		counters int
+	}{
+		{"stale", true, 0},
+		{"keep_last", false, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var failing int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&failing) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL, WithStaleOnFailure(tc.stale))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := testutil.CollectAndCount(c, "http_request_200counter", "http_request_500counter"); n != 2 {
+				t.Fatalf("healthy target exposes %d counters, want 2", n)
+			}
+			atomic.StoreInt32(&failing, 1)
+			if n := testutil.CollectAndCount(c, "http_request_200counter", "http_request_500counter"); n != tc.counters {
+				t.Errorf("failing target exposes %d counters, want %d", n, tc.counters)
+			}
+			if up := gatherValue(t, c, "httpserver_up"); up != 0 {
+				t.Errorf("httpserver_up = %v, want 0", up)
+			}
+			if tc.stale {
+				return
+			}
+			if value := gatherValue(t, c, "http_request_200counter"); value != 5 {
+				t.Errorf("http_request_200counter = %v, want the last value 5", value)
+			}
+		})
+	}
+}
+
+// recordingLogger keeps the messages logged at each level
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (l *recordingLogger) log(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.messages == nil {
+		l.messages = map[string][]string{}
+	}
+	l.messages[level] = append(l.messages[level], fmt.Sprintf(format, args...))
+}
+
+// Debugf
+func (l *recordingLogger) Debugf(format string, args ...interface{}) { l.log("debug", format, args...) }
+
+// Infof
+func (l *recordingLogger) Infof(format string, args ...interface{}) { l.log("info", format, args...) }
+
+// Warnf
+func (l *recordingLogger) Warnf(format string, args ...interface{}) { l.log("warn", format, args...) }
+
+// Errorf
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.log("error", format, args...) }
+
+// logged returns the messages logged at level
+func (l *recordingLogger) logged(level string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.messages[level]...)
+}
+
+// gatherValue returns the value of the single sample of metric gathered from c
+func gatherValue(t *testing.T, c prometheus.Collector, metric string) float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == metric {
+			m := family.GetMetric()[0]
+			if m.GetCounter() != nil {
+				return m.GetCounter().GetValue()
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("%s not collected", metric)
+	return 0
+}
+
+func TestLargeCounterWarning(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		want float64
+		warn bool
+	}{
+		{"1234", 1234, false},
+		{"9007199254740992", 1 << 53, false},
+		{"9007199254740993", 1 << 53, true},
+		{"18446744073709551615", 18446744073709551615, true},
+		{"1e20", 1e20, false},
+		{`"9007199254740993"`, 1 << 53, true},
+	} {
+		t.Run(tc.raw, func(t *testing.T) {
+			server := statsServer(t, http.StatusOK, `{"http200Requestcounter": `+tc.raw+`}`)
+			logger := &recordingLogger{}
+			c, err := NewCollector(server.URL, WithLogger(logger))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				if got := gatherValue(t, c, "http_request_200counter"); got != tc.want {
+					t.Errorf("value = %v, want %v", got, tc.want)
+				}
+			}
+			var warnings int
+			for _, message := range logger.logged("warn") {
+				if strings.Contains(message, "exceeds 2^53") {
+					warnings++
+				}
+			}
+			switch {
+			case tc.warn && warnings != 1:
+				t.Errorf("warned %d times over two scrapes, want once", warnings)
+			case !tc.warn && warnings != 0:
+				t.Errorf("warned %d times, want none", warnings)
+			}
+		})
+	}
+}
+
+func TestIPv6Target(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	hosts := make(chan string, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case hosts <- r.Host + r.URL.Path:
+		default:
+		}
+		w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+	}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	if !strings.HasPrefix(server.URL, "http://[::1]:") {
+		t.Fatalf("server URL %s is not an IPv6 literal", server.URL)
+	}
+	c, err := NewCollector(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up := gatherValue(t, c, "httpserver_up"); up != 1 {
+		t.Errorf("httpserver_up = %v, want 1", up)
+	}
+	if got, want := <-hosts, strings.TrimPrefix(server.URL, "http://")+defaultStatsPath; got != want {
+		t.Errorf("requested %s, want %s", got, want)
+	}
+}
+
+func TestPathURL(t *testing.T) {
+	for _, tc := range []struct {
+		target, path, want string
+	}{
+		{"http://localhost:8080", "/stats", "http://localhost:8080/stats"},
+		{"http://localhost:8080/", "/stats", "http://localhost:8080/stats"},
+		{"http://localhost:8080/api/", "/stats", "http://localhost:8080/api/stats"},
+		{"http://[::1]:8080", "/stats", "http://[::1]:8080/stats"},
+		{"http://[fe80::1%25eth0]:8080/", "/stats", "http://[fe80::1%25eth0]:8080/stats"},
+		{"https://[2001:db8::1]/base?token=a#frag", "/stats?verbose=1", "https://[2001:db8::1]/base/stats?token=a&verbose=1"},
+	} {
+		c, err := NewCollector(tc.target)
+		if err != nil {
+			t.Fatalf("NewCollector(%s): %v", tc.target, err)
+		}
+		if got := c.pathURL(tc.path); got != tc.want {
+			t.Errorf("pathURL(%s, %s) = %s, want %s", tc.target, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		require     bool
+		wantErr     string
+	}{
+		{"json", "application/json", `{"http200Requestcounter": 5}`, true, ""},
+		{"json_charset", "application/json; charset=utf-8", `{"http200Requestcounter": 5}`, true, ""},
+		{"html_error_page", "text/html", `<html><body>Bad Gateway</body></html>`, true, `unexpected Content-Type "text/html"`},
+		{"missing", "", `{"http200Requestcounter": 5}`, true, `unexpected Content-Type ""`},
+		{"lenient_text", "text/plain", `{"http200Requestcounter": 5}`, false, ""},
+		{"lenient_html", "text/html", `<html></html>`, false, "invalid character"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{tc.contentType}
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL, WithRequireJSON(tc.require))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = c.fetchStats(context.Background(), defaultStatsPath)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			var parseErr *ErrParse
+			if !errors.As(err, &parseErr) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want an ErrParse containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTargetResponseStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		want     map[string]float64
+	}{
+		{"ok", []int{200, 200}, map[string]float64{"200": 2}},
+		{"ok_and_unavailable", []int{200, 503, 200}, map[string]float64{"200": 2, "503": 1}},
+		{"failures_only", []int{500, 404, 500}, map[string]float64{"404": 1, "500": 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var next int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statuses[atomic.AddInt32(&next, 1)-1])
+				w.Write([]byte(`{"http200Requestcounter": 5}`))
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range tc.statuses {
+				testutil.CollectAndCount(c)
+			}
+			if n := testutil.CollectAndCount(c.targetStatus); n != len(tc.want) {
+				t.Errorf("%d status codes counted, want %d", n, len(tc.want))
+			}
+			for code, want := range tc.want {
+				if got := testutil.ToFloat64(c.targetStatus.WithLabelValues(code)); got != want {
+					t.Errorf("code %s counted %v times, want %v", code, got, want)
+				}
+			}
+		})
+	}
+}
+
+// gatherNames returns the names of the metric families gathered from c
+func gatherNames(t *testing.T, c prometheus.Collector) map[string]bool {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	return names
+}
+
+func TestInclude(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		include  []string
+		present  []string
+		excluded []string
+	}{
+		{
+			name:    "all_by_default",
+			present: []string{"httpserver_up", "http_request_200counter", "http_request_500counter", "exporter_unknown_fields_total"},
+		},
+		{
+			name:     "allowlist",
+			include:  []string{"httpserver_up", "http_request_200counter"},
+			present:  []string{"httpserver_up", "http_request_200counter"},
+			excluded: []string{"http_request_500counter", "httpserver_endpoint_up", "exporter_unknown_fields_total", "httpserver_exporter_uptime_seconds"},
+		},
+		{
+			name:     "self_metric_only",
+			include:  []string{"httpserver_scrape_errors_total"},
+			excluded: []string{"httpserver_up", "http_request_200counter"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := statsServer(t, http.StatusOK, `{"http200Requestcounter": 5, "http500Requestcounter": 1}`)
+			c, err := NewCollector(server.URL, WithInclude(tc.include...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := gatherNames(t, c)
+			for _, name := range tc.present {
+				if !names[name] {
+					t.Errorf("%s is missing", name)
+				}
+			}
+			for _, name := range tc.excluded {
+				if names[name] {
+					t.Errorf("%s is exposed", name)
+				}
+			}
+			if len(tc.include) > 0 {
+				allowed := map[string]bool{}
+				for _, name := range tc.include {
+					allowed[name] = true
+				}
+				for name := range names {
+					if !allowed[name] {
+						t.Errorf("%s is exposed but not in the allowlist", name)
+					}
+				}
+				descs := make(chan *prometheus.Desc, 100)
+				c.Describe(descs)
+				close(descs)
+				for desc := range descs {
+					described := false
+					for name := range allowed {
+						described = described || strings.Contains(desc.String(), `fqName: "`+name+`"`)
+					}
+					if !described {
+						t.Errorf("%s is described but not in the allowlist", desc)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLogsRedactPassword(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		down   bool
+		fails  bool
+	}{
+		{name: "target_down", down: true, fails: true},
+		{name: "bad_status", status: http.StatusUnauthorized, body: `{}`, fails: true},
+		{name: "malformed_json", status: http.StatusOK, body: `{"http200Requestcounter":`, fails: true},
+		{name: "success", status: http.StatusOK, body: `{"http200Requestcounter": 5}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := statsServer(t, tc.status, tc.body)
+			if tc.down {
+				server.Close()
+			}
+			target := strings.Replace(server.URL, "http://", "http://admin:s3cret@", 1)
+			logger := &recordingLogger{}
+			c, err := NewCollector(target, WithLogger(logger))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CollectAndCount(c)
+			if err := c.Check(context.Background()); err != nil && strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("check error leaks the password: %v", err)
+			}
+			if tc.fails && len(logger.logged("error")) == 0 {
+				t.Error("the failure was not logged")
+			}
+			for _, level := range []string{"debug", "info", "warn", "error"} {
+				for _, message := range logger.logged(level) {
+					if strings.Contains(message, "s3cret") {
+						t.Errorf("%s message leaks the password: %s", level, message)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUptime(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		atLeast float64
+	}{
+		{"collector_start", nil, 0},
+		{"process_start", []Option{WithStartTime(time.Now().Add(-time.Hour))}, 3600},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := statsServer(t, http.StatusOK, `{}`)
+			c, err := NewCollector(server.URL, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first := gatherValue(t, c, "httpserver_exporter_uptime_seconds")
+			time.Sleep(20 * time.Millisecond)
+			second := gatherValue(t, c, "httpserver_exporter_uptime_seconds")
+			if first < tc.atLeast {
+				t.Errorf("uptime %v, want at least %v", first, tc.atLeast)
+			}
+			if second-first < 0.02 {
+				t.Errorf("uptime went from %v to %v over 20ms", first, second)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	const limit = 64
+	// valid JSON of n bytes, so a truncated read would parse
+	payload := func(n int) []byte {
+		body := []byte(`{"http200Requestcounter": 5}`)
+		return append(body, bytes.Repeat([]byte(" "), n-len(body))...)
+	}
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(b)
+		w.Close()
+		return buf.Bytes()
+	}
+	for _, tc := range []struct {
+		name    string
+		body    []byte
+		gzip    bool
+		chunked bool
+		tooBig  bool
+	}{
+		{name: "at_limit", body: payload(limit)},
+		{name: "content_length", body: payload(limit + 1), tooBig: true},
+		{name: "chunked", body: payload(10 * limit), chunked: true, tooBig: true},
+		{name: "gzip_at_limit", body: gzipped(payload(limit)), gzip: true},
+		{name: "gzip_expanding", body: gzipped(payload(100 * limit)), gzip: true, tooBig: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				if tc.chunked {
+					w.(http.Flusher).Flush()
+				}
+				w.Write(tc.body)
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL, WithMaxBodyBytes(limit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			scrape, _, err := c.fetchStats(context.Background(), defaultStatsPath)
+			if !tc.tooBig {
+				if err != nil || scrape.stats == nil {
+					t.Errorf("fetch failed: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrBodyTooLarge) {
+				t.Errorf("error = %v, want ErrBodyTooLarge", err)
+			}
+			if scrape.stats != nil {
+				t.Errorf("truncated body parsed as %v", scrape.stats)
+			}
+			if n := testutil.CollectAndCount(c, "http_request_200counter"); n != 0 {
+				t.Errorf("%d samples collected from a truncated body", n)
+			}
+		})
+	}
+}
+
+func TestTargetAuthorization(t *testing.T) {
+	basic := func(user, password string) string {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.SetBasicAuth(user, password)
+		return request.Header.Get("Authorization")
+	}
+	for _, tc := range []struct {
+		name string
+		// option authenticates with the secret in file
+		option func(file string) Option
+		// secrets are written to the file before each scrape
+		secrets []string
+		want    []string
+	}{
+		{
+			name:    "bearer_token",
+			option:  func(file string) Option { return WithBearerTokenFile(file) },
+			secrets: []string{"token1\n", "token2"},
+			want:    []string{"Bearer token1", "Bearer token2"},
+		},
+		{
+			name:    "basic_auth",
+			option:  func(file string) Option { return WithBasicAuth("admin", file) },
+			secrets: []string{"s3cret\n", "rotated"},
+			want:    []string{basic("admin", "s3cret"), basic("admin", "rotated")},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			authorization := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization <- r.Header.Get("Authorization")
+				w.Write([]byte(`{"http200Requestcounter": 5}`))
+			}))
+			defer server.Close()
+			file := filepath.Join(t.TempDir(), "secret")
+			c, err := NewCollector(server.URL, tc.option(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, secret := range tc.secrets {
+				if err := os.WriteFile(file, []byte(secret), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if up := gatherValue(t, c, "httpserver_up"); up != 1 {
+					t.Errorf("scrape %d: httpserver_up = %v, want 1", i, up)
+				}
+				if got := <-authorization; got != tc.want[i] {
+					t.Errorf("scrape %d: Authorization = %q, want %q", i, got, tc.want[i])
+				}
+			}
+			// a missing file fails the scrape before any request
+			if err := os.Remove(file); err != nil {
+				t.Fatal(err)
+			}
+			err = c.Check(context.Background())
+			if reason := errorReason(err); reason != "auth" {
+				t.Errorf("error %v has reason %q, want auth", err, reason)
+			}
+			select {
+			case got := <-authorization:
+				t.Errorf("request sent without credentials file, Authorization %q", got)
+			default:
+			}
+		})
+	}
+	if _, err := NewCollector("http://localhost", WithBearerTokenFile("token"), WithBasicAuth("admin", "password")); err == nil {
+		t.Error("bearer token and basic auth accepted together")
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+	w.Close()
+	gzipped := buf.Bytes()
+	for _, tc := range []struct {
+		name        string
+		body        []byte
+		disableGzip bool
+		corrupt     bool
+	}{
+		{name: "gzip", body: gzipped},
+		{name: "not_gzip", body: []byte(`{"http200Requestcounter": 5}`), corrupt: true},
+		{name: "truncated", body: gzipped[:len(gzipped)-10], corrupt: true},
+		{name: "gzip_disabled", body: gzipped, disableGzip: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			acceptEncoding := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding <- r.Header.Get("Accept-Encoding")
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(tc.body)
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL, WithDisableGzip(tc.disableGzip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Check(context.Background())
+			if want := map[bool]string{false: "gzip", true: "identity"}[tc.disableGzip]; <-acceptEncoding != want {
+				t.Errorf("Accept-Encoding is not %q", want)
+			}
+			if tc.disableGzip {
+				// the body is still decompressed, as Content-Encoding says
+				if err != nil {
+					t.Errorf("check failed: %v", err)
+				}
+				return
+			}
+			if !tc.corrupt {
+				if err != nil {
+					t.Fatalf("check failed: %v", err)
+				}
+				if value := gatherValue(t, c, "http_request_200counter"); value != 5 {
+					t.Errorf("http_request_200counter = %v, want 5", value)
+				}
+				return
+			}
+			if !errors.Is(err, ErrDecode) {
+				t.Errorf("error = %v, want ErrDecode", err)
+			}
+			if reason := errorReason(err); reason != "decode" {
+				t.Errorf("error reason = %q, want decode", reason)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytesFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stats.json")
+	if err := os.WriteFile(file, []byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		limit  int64
+		tooBig bool
+	}{
+		{limit: 1024},
+		{limit: 16, tooBig: true},
+	} {
+		c, err := NewCollector("file://"+file, WithMaxBodyBytes(tc.limit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = c.fetchStats(context.Background(), defaultStatsPath)
+		if tooBig := errors.Is(err, ErrBodyTooLarge); tooBig != tc.tooBig {
+			t.Errorf("limit %d: error = %v, want too large %v", tc.limit, err, tc.tooBig)
+		}
+	}
+}
+
+func TestConsecutiveScrapeFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		failures []bool
+		want     []float64
+	}{
+		{"success", []bool{false, false}, []float64{0, 0}},
+		{"down", []bool{true, true, true}, []float64{1, 2, 3}},
+		{"flapping", []bool{false, true, true, false, true, false}, []float64{0, 1, 2, 0, 1, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var failing int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&failing) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte(`{"http200Requestcounter": 5}`))
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, fail := range tc.failures {
+				if fail {
+					atomic.StoreInt32(&failing, 1)
+				} else {
+					atomic.StoreInt32(&failing, 0)
+				}
+				if got := gatherValue(t, c, "httpserver_consecutive_scrape_failures"); got != tc.want[i] {
+					t.Errorf("scrape %d: consecutive failures = %v, want %v", i, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+	var failing, requests int32 = 1, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"http200Requestcounter": 5}`))
+	}))
+	defer server.Close()
+	c, err := NewCollector(server.URL, WithCircuitBreaker(2, cooldown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	for _, step := range []struct {
+		name string
+		// wait before the scrape, recover the target before it
+		wait    time.Duration
+		recover bool
+		// requests is the total sent to the target after the scrape
+		requests int32
+		up, open float64
+	}{
+		{name: "first_failure", requests: 1, up: 0, open: 0},
+		{name: "threshold_reached", requests: 2, up: 0, open: 1},
+		{name: "skipped", requests: 2, up: 0, open: 1},
+		{name: "skipped_after_recovery", recover: true, requests: 2, up: 0, open: 1},
+		{name: "resumed_after_cooldown", wait: cooldown, requests: 3, up: 1, open: 0},
+	} {
+		if step.recover {
+			atomic.StoreInt32(&failing, 0)
+		}
+		time.Sleep(step.wait)
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := map[string]float64{}
+		for _, family := range families {
+			values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+		}
+		if got := atomic.LoadInt32(&requests); got != step.requests {
+			t.Errorf("%s: %d requests to the target, want %d", step.name, got, step.requests)
+		}
+		if values["httpserver_up"] != step.up {
+			t.Errorf("%s: httpserver_up = %v, want %v", step.name, values["httpserver_up"], step.up)
+		}
+		if values["httpserver_target_circuit_open"] != step.open {
+			t.Errorf("%s: httpserver_target_circuit_open = %v, want %v", step.name, values["httpserver_target_circuit_open"], step.open)
+		}
+	}
+}
+
+func TestSecondsSinceLastSuccess(t *testing.T) {
+	const name = "httpserver_seconds_since_last_success"
+	var failing int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"http200Requestcounter": 5}`))
+	}))
+	defer server.Close()
+	c, err := NewCollector(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		name    string
+		failing int32
+		// min and max of the gauge, absent when max is 0
+		min, max float64
+	}{
+		{name: "before_first_success", failing: 1},
+		{name: "after_success", failing: 0, max: 0.5},
+		{name: "failing_since", failing: 1, min: 0.05, max: 5},
+		{name: "recovered", failing: 0, max: 0.5},
+	} {
+		if step.name == "failing_since" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		atomic.StoreInt32(&failing, step.failing)
+		if step.max == 0 {
+			if gatherNames(t, c)[name] {
+				t.Errorf("%s: %s exposed", step.name, name)
+			}
+			continue
+		}
+		if got := gatherValue(t, c, name); got < step.min || got > step.max {
+			t.Errorf("%s: %s = %v, want between %v and %v", step.name, name, got, step.min, step.max)
+		}
+	}
+}
+
+func TestUpMeansReachable(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		reachable bool
+		status    int
+		down      bool
+		up        float64
+		// unhealthy is -1 when the gauge is absent
+		unhealthy float64
+	}{
+		{"strict_ok", false, http.StatusOK, false, 1, -1},
+		{"strict_bad_status", false, http.StatusServiceUnavailable, false, 0, -1},
+		{"strict_down", false, 0, true, 0, -1},
+		{"reachable_ok", true, http.StatusOK, false, 1, 0},
+		{"reachable_bad_status", true, http.StatusServiceUnavailable, false, 1, 1},
+		{"reachable_down", true, 0, true, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := statsServer(t, tc.status, `{"http200Requestcounter": 5}`)
+			if tc.down {
+				server.Close()
+			}
+			c, err := NewCollector(server.URL, WithUpMeansReachable(tc.reachable), WithCircuitBreaker(2, time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the breaker opening on the second scrape hides the gauge
+			switch {
+			case tc.unhealthy < 0:
+				if gatherNames(t, c)["httpserver_target_unhealthy"] {
+					t.Error("httpserver_target_unhealthy exposed without -target.up-means-reachable")
+				}
+			default:
+				if unhealthy := gatherValue(t, c, "httpserver_target_unhealthy"); unhealthy != tc.unhealthy {
+					t.Errorf("httpserver_target_unhealthy = %v, want %v", unhealthy, tc.unhealthy)
+				}
+			}
+			// past the breaker threshold, which only failed fetches count towards
+			for i := 0; i < 3; i++ {
+				if up := gatherValue(t, c, "httpserver_up"); up != tc.up {
+					t.Errorf("scrape %d: httpserver_up = %v, want %v", i, up, tc.up)
+				}
+			}
+			if up, _ := c.TargetUp(); up != (tc.up == 1) {
+				t.Errorf("TargetUp = %v, want %v", up, tc.up == 1)
+			}
+			if open := gatherValue(t, c, "httpserver_target_circuit_open"); open != 1-tc.up {
+				t.Errorf("httpserver_target_circuit_open = %v, want %v", open, 1-tc.up)
+			}
+		})
+	}
+}
+
+func TestStatsRequestURI(t *testing.T) {
+	requests := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.RequestURI
+		w.Write([]byte(`{"http200Requestcounter": 5}`))
+	}))
+	defer server.Close()
+	for _, tc := range []struct {
+		target    string
+		statsPath string
+		want      string
+	}{
+		{"", "", "/stats"},
+		{"/", "", "/stats"},
+		{"/api", "", "/api/stats"},
+		{"/api/", "", "/api/stats"},
+		{"/?token=abc", "", "/stats?token=abc"},
+		{"/api/?token=abc#top", "", "/api/stats?token=abc"},
+		{"/api?token=abc", "/v2/stats?token=def&verbose=1", "/api/v2/stats?token=def&verbose=1"},
+		{"/a%2Fb/", "", "/a%2Fb/stats"},
+	} {
+		var opts []Option
+		if tc.statsPath != "" {
+			opts = append(opts, WithStatsPath(tc.statsPath))
+		}
+		c, err := NewCollector(server.URL+tc.target, opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.target, err)
+		}
+		if err := c.Check(context.Background()); err != nil {
+			t.Fatalf("%s: %v", tc.target, err)
+		}
+		if got := <-requests; got != tc.want {
+			t.Errorf("target %q with stats path %q requested %s, want %s", tc.target, tc.statsPath, got, tc.want)
+		}
+	}
+}
+
+func TestLogsUnparsableBody(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		logged string
+	}{
+		{name: "parsed", body: `{"http200Requestcounter": 5}`},
+		{name: "unparsable", body: `{"http200Requestcounter":`, logged: `"{\"http200Requestcounter\":"`},
+		{name: "truncated", body: `{"padding": "` + strings.Repeat("x", 2*maxLoggedBody), logged: `"{\"padding\": \"` + strings.Repeat("x", maxLoggedBody-len(`{"padding": "`)) + `..."`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			c, err := NewCollector(statsServer(t, http.StatusOK, tc.body).URL, WithLogger(logger))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CollectAndCount(c)
+			if info := logger.logged("info"); len(info) > 0 {
+				t.Errorf("logged at info: %q", info)
+			}
+			// once by Collect, which names the path
+			if errs := logger.logged("error"); tc.logged != "" && (len(errs) != 1 || !strings.Contains(errs[0], "/stats")) {
+				t.Errorf("logged errors %q, want one for /stats", errs)
+			}
+			var bodies []string
+			for _, message := range logger.logged("debug") {
+				if strings.HasPrefix(message, "Unparsable") {
+					bodies = append(bodies, message)
+				}
+			}
+			if tc.logged == "" {
+				if len(bodies) > 0 {
+					t.Errorf("logged the body of a parsed response: %q", bodies)
+				}
+				return
+			}
+			if len(bodies) != 1 || !strings.HasSuffix(bodies[0], ": "+tc.logged) {
+				t.Errorf("logged %q, want the body %s", bodies, tc.logged)
+			}
+		})
+	}
+}
+
+// panicFetcher panics instead of fetching
+type panicFetcher struct{}
+
+// Fetch
+func (panicFetcher) Fetch(context.Context) ([]byte, FetchInfo, error) {
+	panic("fetcher bug")
+}
+
+func TestFetcherPanic(t *testing.T) {
+	c, err := NewCollector("http://panic.invalid", WithFetcher(defaultStatsPath, panicFetcher{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up := gatherValue(t, c, "httpserver_up"); up != 0 {
+		t.Errorf("httpserver_up = %v, want 0", up)
+	}
+	if panics := testutil.ToFloat64(c.panics); panics != 1 {
+		t.Errorf("%v panics counted, want 1", panics)
+	}
+	if _, _, err := c.fetchStats(context.Background(), defaultStatsPath); errorReason(err) != "panic" {
+		t.Errorf("fetch failed with reason %q, want panic", errorReason(err))
+	}
+}
+
+func TestCollectMetricPanic(t *testing.T) {
+	server := statsServer(t, http.StatusOK, `{"http200Requestcounter": 5, "http500Requestcounter": 1}`)
+	c, err := NewCollector(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a variable label without a value makes the metric constructor panic
+	broken := c.metrics[0].name
+	c.metrics[0].desc = prometheus.NewDesc(broken, "broken", []string{"missing"}, nil)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var invalid int
+	names := map[string]bool{}
+	for metric := range ch {
+		if err := metric.Write(&dto.Metric{}); err != nil {
+			invalid++
+			continue
+		}
+		names[metric.Desc().String()] = true
+	}
+	if invalid != 1 {
+		t.Errorf("%d invalid metrics collected, want 1", invalid)
+	}
+	if panics := testutil.ToFloat64(c.panics); panics != 1 {
+		t.Errorf("%v panics counted, want 1", panics)
+	}
+	for _, metric := range []string{c.metrics[1].name, "httpserver_up"} {
+		found := false
+		for desc := range names {
+			found = found || strings.Contains(desc, `"`+metric+`"`)
+		}
+		if !found {
+			t.Errorf("%s not collected next to the panicking metric", metric)
+		}
+	}
+}
+
+// mappingCollector exports the stats body of /stats with the metrics config
+func mappingCollector(t *testing.T, config, body string, opts ...Option) *Collector {
+	t.Helper()
+	mappingFile := filepath.Join(t.TempDir(), "metrics.yml")
+	if err := os.WriteFile(mappingFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithMapping(mapping), WithFetcher(defaultStatsPath, StaticFetcher{Body: []byte(body)})}, opts...)
+	c, err := NewCollector("http://mapping.invalid", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestValuePolicies(t *testing.T) {
+	nan := math.NaN()
+	for _, tc := range []struct {
+		name string
+		// settings of the metric in the metrics config
+		metric string
+		value  string
+		onNull string
+		// want is only checked when the sample is exported
+		exported bool
+		want     float64
+		invalid  float64
+	}{
+		{"null_default", "", "null", "", true, 0, 0},
+		{"null_skip", "on_null: skip", "null", "", false, 0, 0},
+		{"null_zero", "on_null: zero", "null", "skip", true, 0, 0},
+		{"null_nan", "on_null: nan", "null", "", true, nan, 0},
+		{"null_global_skip", "", "null", "skip", false, 0, 0},
+		{"null_global_nan", "", "null", "nan", true, nan, 0},
+		{"nan_gauge", "type: gauge", `"NaN"`, "", true, nan, 0},
+		{"nan_counter", "", `"NaN"`, "", true, nan, 0},
+		{"negative_counter", "", "-3", "", false, 0, 1},
+		{"negative_counter_clamped", "clamp_min: 0", "-3", "", true, 0, 0},
+		{"negative_gauge", "type: gauge", "-3", "", true, -3, 0},
+		{"negative_gauge_clamped", "type: gauge, clamp_min: -1", "-3", "", true, -1, 0},
+		{"positive_counter_clamped", "clamp_min: 0", "3", "", true, 3, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := "metrics:\n  - {name: value, field: v, " + tc.metric + "}\n"
+			c := mappingCollector(t, config, `{"v": `+tc.value+`}`, WithOnNull(tc.onNull))
+			if n := testutil.CollectAndCount(c, "value"); n != map[bool]int{false: 0, true: 1}[tc.exported] {
+				t.Fatalf("%d samples of value exported, want exported = %v", n, tc.exported)
+			}
+			if tc.exported {
+				got := gatherValue(t, c, "value")
+				if got != tc.want && !(math.IsNaN(got) && math.IsNaN(tc.want)) {
+					t.Errorf("value = %v, want %v", got, tc.want)
+				}
+			}
+			if invalid := testutil.ToFloat64(c.invalidValues); invalid != tc.invalid {
+				t.Errorf("%s = %v, want %v", invalidValuesName, invalid, tc.invalid)
+			}
+		})
+	}
+}
+
+func TestDefaultMappingFields(t *testing.T) {
+	body := `{"requests_ok": 7, "requests_failed": 2, "http200Requestcounter": 5, "http500Requestcounter": 1}`
+	c, err := NewCollector("http://fields.invalid",
+		WithMapping(DefaultMapping("requests_ok", "requests_failed")),
+		WithFetcher(defaultStatsPath, StaticFetcher{Body: []byte(body)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for metric, want := range map[string]float64{"http_request_200counter": 7, "http_request_500counter": 2} {
+		if value := gatherValue(t, c, metric); value != want {
+			t.Errorf("%s = %v, want %v", metric, value, want)
+		}
+	}
+}
+
+func TestEndpointRequest(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "query.json")
+	if err := os.WriteFile(bodyFile, []byte(`{"select": "file"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		endpoint string
+		// method, body and content type received by the target
+		method, body, contentType string
+		invalid                   bool
+	}{
+		{"get", "path: /api/query", http.MethodGet, "", "", false},
+		{"post_body", `{path: /api/query, method: post, body: '{"select": "all"}'}`, http.MethodPost, `{"select": "all"}`, "application/json", false},
+		{"post_body_file", "{path: /api/query, method: POST, body_file: " + bodyFile + "}", http.MethodPost, `{"select": "file"}`, "application/json", false},
+		{"content_type", "{path: /api/query, method: PUT, body: 'select=all', content_type: application/x-www-form-urlencoded}", http.MethodPut, "select=all", "application/x-www-form-urlencoded", false},
+		{"get_body", "{path: /api/query, body: '{}'}", "", "", "", true},
+		{"body_and_file", "{path: /api/query, method: POST, body: '{}', body_file: " + bodyFile + "}", "", "", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var method, body, contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ := io.ReadAll(r.Body)
+				method, body, contentType = r.Method, string(received), r.Header.Get("Content-Type")
+				w.Write([]byte(`{"ok": 3}`))
+			}))
+			defer server.Close()
+			mappingFile := filepath.Join(t.TempDir(), "metrics.yml")
+			config := "metrics:\n  - {name: ok_total, path: /api/query, field: ok}\nendpoints:\n  - " + tc.endpoint + "\n"
+			if err := os.WriteFile(mappingFile, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mapping, err := LoadMapping(mappingFile)
+			if tc.invalid {
+				if err == nil {
+					t.Fatal("invalid endpoint accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewCollector(server.URL, WithMapping(mapping))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value := gatherValue(t, c, "ok_total"); value != 3 {
+				t.Errorf("ok_total = %v, want 3", value)
+			}
+			if method != tc.method || body != tc.body || contentType != tc.contentType {
+				t.Errorf("target received %s %q as %q, want %s %q as %q", method, body, contentType, tc.method, tc.body, tc.contentType)
+			}
+		})
+	}
+}
+
+func TestHelpStrings(t *testing.T) {
+	c := mappingCollector(t, `
+metrics:
+  - {name: cache_hits_total, help: Requests answered from the cache., field: hits}
+  - {name: cache_misses_total, field: misses}
+`, `{"hits": 7, "misses": 2}`)
+	expected := `
+# HELP cache_hits_total Requests answered from the cache.
+# TYPE cache_hits_total counter
+cache_hits_total 7
+# HELP cache_misses_total Value of misses from /stats.
+# TYPE cache_misses_total counter
+cache_misses_total 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "cache_hits_total", "cache_misses_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValueTypes(t *testing.T) {
+	var value int32 = 7
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"served": %d, "queued": %d}`, atomic.LoadInt32(&value), atomic.LoadInt32(&value))
+	}))
+	defer server.Close()
+	mappingFile := filepath.Join(t.TempDir(), "metrics.yml")
+	if err := os.WriteFile(mappingFile, []byte(`
+metrics:
+  - {name: served_total, help: Requests served., type: counter, field: served}
+  - {name: queued, help: Requests queued., type: gauge, field: queued}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &recordingLogger{}
+	c, err := NewCollector(server.URL, WithMapping(mapping), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP queued Requests queued.
+# TYPE queued gauge
+queued 7
+# HELP served_total Requests served.
+# TYPE served_total counter
+served_total 7
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "served_total", "queued"); err != nil {
+		t.Error(err)
+	}
+
+	// only the counter is expected to never go down
+	atomic.StoreInt32(&value, 3)
+	testutil.CollectAndCount(c)
+	var warnings []string
+	for _, message := range logger.logged("warn") {
+		if strings.Contains(message, "decreased") {
+			warnings = append(warnings, message)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "served_total") {
+		t.Errorf("decrease warnings %q, want one for served_total", warnings)
+	}
+}
+
+func TestInvalidValueType(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "metrics.yml")
+	if err := os.WriteFile(mappingFile, []byte("metrics:\n  - {name: queued, type: histogram, field: queued}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMapping(mappingFile); err == nil {
+		t.Error("histogram type accepted")
+	}
+}
+
+func TestInstanceID(t *testing.T) {
+	server := statsServer(t, http.StatusOK, `{"http200Requestcounter": 5, "http500Requestcounter": 1}`)
+	for _, tc := range []struct {
+		name string
+		id   string
+	}{
+		{"unset", ""},
+		{"set", "exporter-a"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewCollector(server.URL, WithInstanceID(tc.id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(c)
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, family := range families {
+				id := ""
+				for _, label := range family.GetMetric()[0].GetLabel() {
+					if label.GetName() == "instance_id" {
+						id = label.GetValue()
+					}
+				}
+				want := tc.id
+				switch family.GetName() {
+				case "http_request_200counter", "http_request_500counter", "httpserver_rate_limited_total":
+					// read from the target's stats
+					want = ""
+				}
+				if id != want {
+					t.Errorf("%s has instance_id %q, want %q", family.GetName(), id, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseAge(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 30, 500000000, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		age, date string
+		want      float64
+		ok        bool
+	}{
+		{"none", "", "", 0, false},
+		{"age", "30", "", 30, true},
+		{"date", "", "Wed, 01 May 2024 12:00:00 GMT", 30, true},
+		{"age_larger", "60", "Wed, 01 May 2024 12:00:00 GMT", 60, true},
+		{"date_larger", "10", "Wed, 01 May 2024 12:00:00 GMT", 30, true},
+		{"date_ahead", "", "Wed, 01 May 2024 12:01:00 GMT", 0, true},
+		{"malformed", "-5", "yesterday", 0, false},
+		{"malformed_age", "soon", "Wed, 01 May 2024 12:00:20 GMT", 10, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			if tc.age != "" {
+				header.Set("Age", tc.age)
+			}
+			if tc.date != "" {
+				header.Set("Date", tc.date)
+			}
+			age, ok := responseAge(header, now)
+			if age != tc.want || ok != tc.ok {
+				t.Errorf("responseAge = %v, %v, want %v, %v", age, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestResponseAgeMetric(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		age     string
+		metrics int
+	}{
+		{"age", "120", 1},
+		{"no_headers", "", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the server adds a Date header unless it is set to nil
+				w.Header()["Date"] = nil
+				if tc.age != "" {
+					w.Header().Set("Age", tc.age)
+				}
+				w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+			}))
+			defer server.Close()
+			c, err := NewCollector(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := testutil.CollectAndCount(c, responseAgeName); n != tc.metrics {
+				t.Fatalf("%d %s samples, want %d", n, responseAgeName, tc.metrics)
+			}
+			if tc.metrics == 0 {
+				return
+			}
+			if age := gatherValue(t, c, responseAgeName); age != 120 {
+				t.Errorf("%s = %v, want 120", responseAgeName, age)
+			}
+		})
+	}
+}
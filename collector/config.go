@@ -0,0 +1,285 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// metricsConfig is the format of the -metrics.config file mapping stats
+// fields of the target's endpoints to metrics
+type metricsConfig struct {
+	Metrics []metricConfig `yaml:"metrics"`
+	// Endpoints changes how paths are requested, GET without body by default
+	Endpoints []endpointConfig `yaml:"endpoints"`
+}
+
+type metricConfig struct {
+	Name string `yaml:"name"`
+	// Help documents the metric, derived from the field and path when empty
+	Help string `yaml:"help"`
+	// Type is counter or gauge, counter when empty
+	Type string `yaml:"type"`
+	// Path of the stats endpoint, /stats when empty
+	Path     string            `yaml:"path"`
+	Field    string            `yaml:"field"`
+	Labels   map[string]string `yaml:"labels"`
+	OnNull   string            `yaml:"on_null"`
+	ClampMin *float64          `yaml:"clamp_min"`
+}
+
+type endpointConfig struct {
+	Path   string `yaml:"path"`
+	Method string `yaml:"method"`
+	// Body or BodyFile is sent with every request, the file is read once at startup
+	Body        string `yaml:"body"`
+	BodyFile    string `yaml:"body_file"`
+	ContentType string `yaml:"content_type"`
+	// Format of the response, json, xml, csv, expvar or prometheus. Expvar
+	// fields of nested objects are joined by dots, memstats.HeapAlloc.
+	// Prometheus endpoints are proxied as they are, prefixed with Namespace
+	Format    string `yaml:"format"`
+	Namespace string `yaml:"namespace"`
+	// Delimiter and LabelColumn of csv endpoints, LabelColumn exposes one
+	// series per row labelled with the column's value
+	Delimiter   string `yaml:"delimiter"`
+	LabelColumn string `yaml:"label_column"`
+	// Command is run instead of requesting the path, which then only names
+	// the source. Its stdout is decoded in Format.
+	Command []string       `yaml:"command"`
+	Timeout model.Duration `yaml:"timeout"`
+	// File is read instead of requesting the path, failing when not
+	// modified for MaxAge
+	File   string         `yaml:"file"`
+	MaxAge model.Duration `yaml:"max_age"`
+}
+
+// endpointRequest describes the request sent to fetch one path of the target
+type endpointRequest struct {
+	method      string
+	body        []byte
+	contentType string
+	format      string
+	namespace   string
+	parser      Parser
+	command     []string
+	timeout     time.Duration
+	file        string
+	maxAge      time.Duration
+}
+
+// Mapping maps stats fields of the target's paths to metrics and tells how
+// the paths are requested
+type Mapping struct {
+	metrics exportedMetrics
+	// requests of the endpoints that are not plain GETs
+	requests map[string]endpointRequest
+}
+
+// DefaultMapping maps the demo server's stats, reading the request counters
+// from the given keys or their defaults when empty
+func DefaultMapping(field200, field500 string) *Mapping {
+	if field200 == "" {
+		field200 = DefaultField200
+	}
+	if field500 == "" {
+		field500 = DefaultField500
+	}
+	return &Mapping{metrics: defaultMetrics(field200, field500)}
+}
+
+// LoadMapping reads the mapping from a metrics config file
+func LoadMapping(file string) (*Mapping, error) {
+	metrics, requests, err := loadMetricsConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{metrics: metrics, requests: requests}, nil
+}
+
+// loadMetricsConfig reads and validates a metrics config file, returning the
+// metrics and the requests of the endpoints that are not plain GETs
+func loadMetricsConfig(file string) (exportedMetrics, map[string]endpointRequest, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading metrics config: %w", err)
+	}
+	var cfg metricsConfig
+	if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("failed parsing metrics config %s: %w", file, err)
+	}
+	if len(cfg.Metrics) == 0 && len(cfg.Endpoints) == 0 {
+		return nil, nil, fmt.Errorf("metrics config %s defines no metrics", file)
+	}
+	metrics := make(exportedMetrics, 0, len(cfg.Metrics))
+	paths := map[string]bool{}
+	for _, m := range cfg.Metrics {
+		if !model.IsValidMetricName(model.LabelValue(m.Name)) {
+			return nil, nil, fmt.Errorf("invalid metric name %q in metrics config", m.Name)
+		}
+		if m.Field == "" {
+			return nil, nil, fmt.Errorf("metric %s has no field in metrics config", m.Name)
+		}
+		switch m.OnNull {
+		case "", onNullSkip, onNullZero, onNullNaN:
+		default:
+			return nil, nil, fmt.Errorf("metric %s has invalid on_null %q, expected skip, zero or nan", m.Name, m.OnNull)
+		}
+		valType := prometheus.CounterValue
+		switch m.Type {
+		case "", "counter":
+		case "gauge":
+			valType = prometheus.GaugeValue
+		default:
+			return nil, nil, fmt.Errorf("metric %s has invalid type %q, expected counter or gauge", m.Name, m.Type)
+		}
+		path := m.Path
+		if path == "" {
+			path = defaultStatsPath
+		}
+		paths[path] = true
+		help := m.Help
+		if help == "" {
+			help = fmt.Sprintf("Value of %s from %s.", m.Field, path)
+		}
+		metrics = append(metrics, exportedMetric{
+			name:        m.Name,
+			help:        help,
+			constLabels: prometheus.Labels(m.Labels),
+			path:        path,
+			field:       m.Field,
+			valType:     valType,
+			onNull:      m.OnNull,
+			clampMin:    m.ClampMin,
+		})
+	}
+	requests := map[string]endpointRequest{}
+	for _, ep := range cfg.Endpoints {
+		path := ep.Path
+		if path == "" {
+			path = defaultStatsPath
+		}
+		if _, ok := requests[path]; ok {
+			return nil, nil, fmt.Errorf("endpoint %s configured twice in metrics config", path)
+		}
+		request, err := newEndpointRequest(ep)
+		if err != nil {
+			return nil, nil, fmt.Errorf("endpoint %s: %w", path, err)
+		}
+		switch {
+		case request.format == formatPrometheus && paths[path]:
+			return nil, nil, fmt.Errorf("endpoint %s is in prometheus format and cannot be mapped by metrics", path)
+		case request.format != formatPrometheus && !paths[path]:
+			return nil, nil, fmt.Errorf("endpoint %s in metrics config is not used by any metric", path)
+		}
+		requests[path] = request
+	}
+	return metrics, requests, nil
+}
+
+// newEndpointRequest validates an endpoint and loads its body
+func newEndpointRequest(ep endpointConfig) (endpointRequest, error) {
+	request := endpointRequest{
+		method:      strings.ToUpper(ep.Method),
+		contentType: ep.ContentType,
+		format:      ep.Format,
+		namespace:   ep.Namespace,
+		command:     ep.Command,
+		timeout:     time.Duration(ep.Timeout),
+		file:        ep.File,
+		maxAge:      time.Duration(ep.MaxAge),
+	}
+	if request.file != "" && len(request.command) > 0 {
+		return request, fmt.Errorf("file and command are mutually exclusive")
+	}
+	if request.file != "" && (ep.Method != "" || ep.Body != "" || ep.BodyFile != "" || ep.ContentType != "") {
+		return request, fmt.Errorf("method, body, body_file and content_type do not apply to files")
+	}
+	if request.file == "" && request.maxAge != 0 {
+		return request, fmt.Errorf("max_age is only supported for files")
+	}
+	if len(request.command) > 0 {
+		if request.command[0] == "" {
+			return request, fmt.Errorf("empty command")
+		}
+		if ep.Method != "" || ep.Body != "" || ep.BodyFile != "" || ep.ContentType != "" {
+			return request, fmt.Errorf("method, body, body_file and content_type do not apply to commands")
+		}
+		if request.timeout <= 0 {
+			request.timeout = defaultCommandTimeout
+		}
+	} else if ep.Timeout != 0 {
+		return request, fmt.Errorf("timeout is only supported for commands")
+	}
+	if request.format == "" {
+		request.format = formatJSON
+	}
+	switch request.format {
+	case formatJSON:
+		request.parser = jsonParser{}
+	case formatXML:
+		request.parser = xmlParser{}
+	case formatExpvar:
+		request.parser = expvarParser{}
+	case formatCSV:
+		parser, err := newCSVParser(ep)
+		if err != nil {
+			return request, err
+		}
+		request.parser = parser
+	case formatPrometheus:
+	default:
+		return request, fmt.Errorf("unknown format %q, expected json, xml, csv, expvar or prometheus", ep.Format)
+	}
+	if request.format != formatCSV && (ep.Delimiter != "" || ep.LabelColumn != "") {
+		return request, fmt.Errorf("delimiter and label_column are only supported for csv endpoints")
+	}
+	if request.namespace != "" && request.format != formatPrometheus {
+		return request, fmt.Errorf("namespace is only supported for prometheus endpoints")
+	}
+	if request.method == "" {
+		request.method = http.MethodGet
+	}
+	if ep.Body != "" && ep.BodyFile != "" {
+		return request, fmt.Errorf("body and body_file are mutually exclusive")
+	}
+	switch {
+	case ep.Body != "":
+		request.body = []byte(ep.Body)
+	case ep.BodyFile != "":
+		body, err := os.ReadFile(ep.BodyFile)
+		if err != nil {
+			return request, fmt.Errorf("failed reading body file: %w", err)
+		}
+		request.body = body
+	}
+	if request.body != nil && (request.method == http.MethodGet || request.method == http.MethodHead) {
+		return request, fmt.Errorf("%s requests cannot have a body", request.method)
+	}
+	if request.body != nil && request.contentType == "" {
+		request.contentType = "application/json"
+	}
+	return request, nil
+}
+
+// newCSVParser
+func newCSVParser(ep endpointConfig) (csvParser, error) {
+	parser := csvParser{delimiter: ',', labelColumn: ep.LabelColumn}
+	if ep.Delimiter != "" {
+		delimiter := []rune(ep.Delimiter)
+		if len(delimiter) != 1 || delimiter[0] == '"' || delimiter[0] == '\n' || delimiter[0] == '\r' {
+			return parser, fmt.Errorf("invalid csv delimiter %q", ep.Delimiter)
+		}
+		parser.delimiter = delimiter[0]
+	}
+	if parser.labelColumn != "" && !model.LabelName(parser.labelColumn).IsValid() {
+		return parser, fmt.Errorf("label_column %q is not a valid label name", parser.labelColumn)
+	}
+	return parser, nil
+}
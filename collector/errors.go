@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// scrapeError tags a failed scrape with the reason reported in the error metric
+type scrapeError struct {
+	reason string
+	err    error
+}
+
+func (e *scrapeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *scrapeError) Unwrap() error {
+	return e.err
+}
+
+// errorReason classifies a failed scrape, preferring the reason it was tagged with
+func errorReason(err error) string {
+	var (
+		scrapeErr *scrapeError
+		statusErr *ErrBadStatus
+		fetchErr  *ErrFetchFailed
+		parseErr  *ErrParse
+	)
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrBodyTooLarge):
+		return "body_too_large"
+	case errors.As(err, &scrapeErr):
+		return scrapeErr.reason
+	case errors.As(err, &statusErr):
+		return "status"
+	case errors.As(err, &fetchErr):
+		return "fetch"
+	case errors.As(err, &parseErr):
+		return "decode"
+	}
+	return "unknown"
+}
+
+// ErrRedirectRefused is returned when the client's redirect policy stops a redirect
+var ErrRedirectRefused = errors.New("redirect refused")
+
+// kinds of failed fetches and parses, matched with errors.Is
+var (
+	// ErrConnect is a request that got no response from the target
+	ErrConnect = errors.New("connection failed")
+	// ErrTimeout is a request or command that timed out
+	ErrTimeout = errors.New("timed out")
+	// ErrBodyTooLarge is a response larger than the body limit
+	ErrBodyTooLarge = errors.New("response exceeds size limit")
+	// ErrDecode is a response that could not be decoded
+	ErrDecode = errors.New("decoding failed")
+)
+
+// ErrFetchFailed is returned when no complete response was received from the target
+type ErrFetchFailed struct {
+	// Kind is ErrConnect, ErrTimeout or ErrBodyTooLarge when known
+	Kind error
+	Err  error
+}
+
+// newFetchError wraps err, telling timeouts from other failures
+func newFetchError(err error) *ErrFetchFailed {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		return &ErrFetchFailed{Kind: ErrBodyTooLarge, Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &ErrFetchFailed{Kind: ErrTimeout, Err: err}
+	}
+	return &ErrFetchFailed{Kind: ErrConnect, Err: err}
+}
+
+func (e *ErrFetchFailed) Error() string {
+	return fmt.Sprintf("fetching target failed: %v", e.Err)
+}
+
+func (e *ErrFetchFailed) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrFetchFailed) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// ErrBadStatus is returned when the target answers with a non-2xx status
+type ErrBadStatus struct {
+	Code int
+}
+
+func (e *ErrBadStatus) Error() string {
+	return fmt.Sprintf("target answered with status %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// ErrParse is returned when the target's response cannot be decoded
+type ErrParse struct {
+	Err error
+}
+
+func (e *ErrParse) Error() string {
+	return fmt.Sprintf("parsing target response failed: %v", e.Err)
+}
+
+func (e *ErrParse) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrParse) Is(target error) bool {
+	return target == ErrDecode
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestErrorReason(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		// down closes the server before the scrape
+		down bool
+		opts []Option
+		// kind is matched with errors.Is, nil when none applies
+		kind   error
+		reason string
+	}{
+		{name: "connect", down: true, kind: ErrConnect, reason: "fetch"},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+			},
+			opts:   []Option{WithTimeout(20 * time.Millisecond)},
+			kind:   ErrTimeout,
+			reason: "timeout",
+		},
+		{
+			name: "body_too_large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"http200Requestcounter": 5}` + strings.Repeat(" ", 100)))
+			},
+			opts:   []Option{WithMaxBodyBytes(64)},
+			kind:   ErrBodyTooLarge,
+			reason: "body_too_large",
+		},
+		{
+			name: "decode",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"http200Requestcounter":`))
+			},
+			kind:   ErrDecode,
+			reason: "decode",
+		},
+		{
+			name: "bad_status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			reason: "status",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.handler)
+			defer server.Close()
+			if tc.down {
+				server.Close()
+			}
+			c, err := NewCollector(server.URL, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = c.fetchStats(context.Background(), defaultStatsPath)
+			if err == nil {
+				t.Fatal("fetch succeeded")
+			}
+			if tc.kind != nil && !errors.Is(err, tc.kind) {
+				t.Errorf("error %v is not %v", err, tc.kind)
+			}
+			if reason := errorReason(err); reason != tc.reason {
+				t.Errorf("errorReason(%v) = %q, want %q", err, reason, tc.reason)
+			}
+			expected := fmt.Sprintf("# HELP httpserver_scrape_errors_total Number of failed scrapes of the target by reason.\n"+
+				"# TYPE httpserver_scrape_errors_total counter\nhttpserver_scrape_errors_total{reason=%q} 1\n", tc.reason)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "httpserver_scrape_errors_total"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestErrorReasonWrapped(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		reason string
+	}{
+		{&scrapeError{reason: "auth", err: errors.New("no token")}, "auth"},
+		{&scrapeError{reason: "redirect", err: newFetchError(ErrRedirectRefused)}, "redirect"},
+		{fmt.Errorf("path /stats: %w", &ErrBadStatus{Code: 500}), "status"},
+		{fmt.Errorf("path /stats: %w", &ErrParse{Err: errors.New("bad")}), "decode"},
+		{newFetchError(context.DeadlineExceeded), "timeout"},
+		{newFetchError(fmt.Errorf("%w of 64 bytes", ErrBodyTooLarge)), "body_too_large"},
+		{newFetchError(errors.New("connection refused")), "fetch"},
+		{errors.New("something else"), "unknown"},
+	} {
+		if reason := errorReason(tc.err); reason != tc.reason {
+			t.Errorf("errorReason(%v) = %q, want %q", tc.err, reason, tc.reason)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+const (
+	// defaultCommandTimeout bounds commands without a configured timeout
+	defaultCommandTimeout = 10 * time.Second
+	// maxCommandStderr is the most stderr logged for a failed command
+	maxCommandStderr = 512
+)
+
+// limitedBuffer fails writes once more than max bytes were written, or
+// drops the excess when truncate is set
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	truncate bool
+}
+
+// Write
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) <= b.max {
+		return b.Buffer.Write(p)
+	}
+	if !b.truncate {
+		return 0, ErrBodyTooLarge
+	}
+	b.Buffer.Write(p[:b.max-b.Len()])
+	return len(p), nil
+}
+
+// runCommand runs a stats command without a shell and returns its stdout
+func (e *Collector) runCommand(ctx context.Context, command []string, timeout time.Duration, maxBytes int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	stdout := &limitedBuffer{max: int(maxBytes)}
+	// stderr is only logged, keep what is shown
+	stderr := &limitedBuffer{max: maxCommandStderr, truncate: true}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		e.logger.Warnf("Command %s wrote to stderr: %s", command[0], stderr.String())
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w after %s", ctx.Err(), timeout)
+		}
+		return nil, &scrapeError{reason: "exec", err: newFetchError(fmt.Errorf("command %s: %w", command[0], err))}
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// stats formats of target endpoints
+const (
+	formatJSON       = "json"
+	formatXML        = "xml"
+	formatCSV        = "csv"
+	formatPrometheus = "prometheus"
+	formatExpvar     = "expvar"
+)
+
+// targetLabel names the target on metrics proxied from prometheus endpoints
+const targetLabel = "target"
+
+// parseFamilies decodes a response in the Prometheus text format
+func parseFamilies(body []byte) ([]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		result = append(result, family)
+	}
+	return result, nil
+}
+
+// collectFamilies re-emits proxied metric families under the namespace, adding the target label
+func (e *Collector) collectFamilies(ch chan<- prometheus.Metric, namespace string, families []*dto.MetricFamily) {
+	for _, family := range families {
+		name := prometheus.BuildFQName(namespace, "", family.GetName())
+		if !e.included(name) {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			metric, err := e.proxiedMetric(name, family, m)
+			if err != nil {
+				e.logger.Warnf("Skipping proxied metric %s: %v", name, err)
+				continue
+			}
+			ch <- metric
+		}
+	}
+}
+
+// proxiedMetric converts one sample of a proxied family to a const metric
+func (e *Collector) proxiedMetric(name string, family *dto.MetricFamily, m *dto.Metric) (prometheus.Metric, error) {
+	labelNames := make([]string, 0, len(m.GetLabel())+1)
+	labelValues := make([]string, 0, len(m.GetLabel())+1)
+	for _, label := range m.GetLabel() {
+		labelName := label.GetName()
+		if labelName == targetLabel {
+			// keep the upstream value without clashing with ours
+			labelName = "exported_" + targetLabel
+		}
+		labelNames = append(labelNames, labelName)
+		labelValues = append(labelValues, label.GetValue())
+	}
+	labelNames = append(labelNames, targetLabel)
+	labelValues = append(labelValues, e.httpServer.Host)
+	desc := e.newDesc(name, family.GetHelp(), labelNames, nil)
+
+	var (
+		metric prometheus.Metric
+		err    error
+	)
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), labelValues...)
+	case dto.MetricType_GAUGE:
+		metric, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), labelValues...)
+	case dto.MetricType_UNTYPED:
+		metric, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), labelValues...)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		buckets := make(map[float64]uint64, len(h.GetBucket()))
+		for _, b := range h.GetBucket() {
+			// the +Inf bucket is implied by the sample count
+			if !math.IsInf(b.GetUpperBound(), 1) {
+				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+			}
+		}
+		metric, err = prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, labelValues...)
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		quantiles := make(map[float64]float64, len(s.GetQuantile()))
+		for _, q := range s.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		metric, err = prometheus.NewConstSummary(desc, s.GetSampleCount(), s.GetSampleSum(), quantiles, labelValues...)
+	default:
+		return nil, fmt.Errorf("unsupported metric type %s", family.GetType())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.TimestampMs != nil {
+		metric = prometheus.NewMetricWithTimestamp(time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond)), metric)
+	}
+	return metric, nil
+}
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// federatedBody is an upstream /metrics with every metric type
+const federatedBody = `# HELP app_requests_total Requests.
+# TYPE app_requests_total counter
+app_requests_total{code="200",target="upstream"} 10
+app_requests_total{code="500"} 2
+# HELP app_temperature Temperature.
+# TYPE app_temperature gauge
+app_temperature -3.5 1700000000000
+# HELP app_untyped Untyped.
+app_untyped 7
+# HELP app_duration_seconds Duration.
+# TYPE app_duration_seconds histogram
+app_duration_seconds_bucket{le="0.1"} 1
+app_duration_seconds_bucket{le="1"} 3
+app_duration_seconds_bucket{le="+Inf"} 4
+app_duration_seconds_sum 4.05
+app_duration_seconds_count 4
+# HELP app_size_bytes Size.
+# TYPE app_size_bytes summary
+app_size_bytes{quantile="0.5"} 50
+app_size_bytes_sum 405
+app_size_bytes_count 4
+`
+
+// federatingCollector proxies body from /metrics of http://app:9100
+func federatingCollector(t *testing.T, namespace, body string, opts ...Option) *Collector {
+	t.Helper()
+	mappingFile := filepath.Join(t.TempDir(), "metrics.yml")
+	config := "endpoints:\n  - {path: /metrics, format: prometheus, namespace: " + namespace + "}\n"
+	if err := os.WriteFile(mappingFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithMapping(mapping), WithFetcher("/metrics", StaticFetcher{Body: []byte(body)})}, opts...)
+	c, err := NewCollector("http://app:9100", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCollectFederated(t *testing.T) {
+	c := federatingCollector(t, "upstream", federatedBody)
+	expected := `
+# HELP upstream_app_requests_total Requests.
+# TYPE upstream_app_requests_total counter
+upstream_app_requests_total{code="200",exported_target="upstream",target="app:9100"} 10
+upstream_app_requests_total{code="500",target="app:9100"} 2
+# HELP upstream_app_temperature Temperature.
+# TYPE upstream_app_temperature gauge
+upstream_app_temperature{target="app:9100"} -3.5 1700000000000
+# HELP upstream_app_untyped Untyped.
+# TYPE upstream_app_untyped untyped
+upstream_app_untyped{target="app:9100"} 7
+# HELP upstream_app_duration_seconds Duration.
+# TYPE upstream_app_duration_seconds histogram
+upstream_app_duration_seconds_bucket{target="app:9100",le="0.1"} 1
+upstream_app_duration_seconds_bucket{target="app:9100",le="1"} 3
+upstream_app_duration_seconds_bucket{target="app:9100",le="+Inf"} 4
+upstream_app_duration_seconds_sum{target="app:9100"} 4.05
+upstream_app_duration_seconds_count{target="app:9100"} 4
+# HELP upstream_app_size_bytes Size.
+# TYPE upstream_app_size_bytes summary
+upstream_app_size_bytes{target="app:9100",quantile="0.5"} 50
+upstream_app_size_bytes_sum{target="app:9100"} 405
+upstream_app_size_bytes_count{target="app:9100"} 4
+`
+	names := []string{"upstream_app_requests_total", "upstream_app_temperature", "upstream_app_untyped", "upstream_app_duration_seconds", "upstream_app_size_bytes"}
+	// proxied families are not described, which only a pedantic registry rejects
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectFederatedErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		opts []Option
+		// proxied is the number of proxied samples, up the target's state
+		proxied int
+		up      float64
+	}{
+		{name: "empty", body: "", proxied: 0, up: 1},
+		{name: "malformed", body: "app_requests_total{code=\"200\" 10\n", proxied: 0, up: 0},
+		{name: "allowlist", body: federatedBody, opts: []Option{WithInclude("upstream_app_untyped", "httpserver_up")}, proxied: 1, up: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := federatingCollector(t, "upstream", tc.body, tc.opts...)
+			proxied := 0
+			for name := range gatherNames(t, c) {
+				if strings.HasPrefix(name, "upstream_") {
+					proxied++
+				}
+			}
+			if proxied != tc.proxied {
+				t.Errorf("%d proxied families, want %d", proxied, tc.proxied)
+			}
+			if up := gatherValue(t, c, "httpserver_up"); up != tc.up {
+				t.Errorf("httpserver_up = %v, want %v", up, tc.up)
+			}
+		})
+	}
+}
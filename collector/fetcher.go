@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+)
+
+// Fetcher sources the raw stats of one path of the target, which the
+// collector decodes in the path's format
+type Fetcher interface {
+	Fetch(ctx context.Context) ([]byte, FetchInfo, error)
+}
+
+// FetchInfo describes how stats were fetched
+type FetchInfo struct {
+	// StatusCode of the HTTP response, 0 when the source is not HTTP
+	StatusCode int
+	Duration   time.Duration
+	// TLS state of the connection, nil for plain HTTP and other sources
+	TLS *tls.ConnectionState
+}
+
+// newFetcher returns the fetcher of path's configured source
+func (e *Collector) newFetcher(path string) Fetcher {
+	endpoint := e.endpoint(path)
+	switch {
+	case len(endpoint.command) > 0:
+		return &commandFetcher{collector: e, command: endpoint.command, timeout: endpoint.timeout}
+	case endpoint.file != "":
+		return &fileFetcher{collector: e, path: path, file: endpoint.file, maxAge: endpoint.maxAge}
+	}
+	return &HTTPFetcher{collector: e, path: path, endpoint: endpoint}
+}
+
+// HTTPFetcher requests a path of the target with the collector's client,
+// headers and credentials. It is the default Fetcher of a path.
+type HTTPFetcher struct {
+	collector *Collector
+	path      string
+	endpoint  endpointRequest
+}
+
+// Fetch
+func (f *HTTPFetcher) Fetch(ctx context.Context) ([]byte, FetchInfo, error) {
+	start := time.Now()
+	body, info, err := f.collector.fetchHTTP(ctx, f.path, f.endpoint)
+	if err != nil {
+		info.Duration = time.Since(start)
+		return nil, info, err
+	}
+	defer body.Close()
+	bodyBytes, err := f.collector.readBody(body)
+	info.Duration = time.Since(start)
+	return bodyBytes, info, err
+}
+
+// commandFetcher runs a command and returns its stdout
+type commandFetcher struct {
+	collector *Collector
+	command   []string
+	timeout   time.Duration
+}
+
+// Fetch
+func (f *commandFetcher) Fetch(ctx context.Context) ([]byte, FetchInfo, error) {
+	start := time.Now()
+	output, err := f.collector.runCommand(ctx, f.command, f.timeout, f.collector.maxBodyBytes)
+	return output, FetchInfo{Duration: time.Since(start)}, err
+}
+
+// fileFetcher reads a stats file, exporting its modification time
+type fileFetcher struct {
+	collector *Collector
+	path      string
+	file      string
+	maxAge    time.Duration
+}
+
+// Fetch
+func (f *fileFetcher) Fetch(context.Context) ([]byte, FetchInfo, error) {
+	start := time.Now()
+	r, mtime, err := openStatsFile(f.file, f.maxAge)
+	if !mtime.IsZero() {
+		f.collector.fileMtime.WithLabelValues(f.path).Set(float64(mtime.UnixNano()) / 1e9)
+	}
+	if err != nil {
+		return nil, FetchInfo{Duration: time.Since(start)}, err
+	}
+	defer r.Close()
+	body, err := f.collector.readBody(r)
+	return body, FetchInfo{Duration: time.Since(start)}, err
+}
+
+// StaticFetcher returns the same body, info and error on every fetch, for
+// tests and stats known ahead of time
+type StaticFetcher struct {
+	Body []byte
+	Info FetchInfo
+	Err  error
+}
+
+// Fetch
+func (f StaticFetcher) Fetch(context.Context) ([]byte, FetchInfo, error) {
+	return f.Body, f.Info, f.Err
+}
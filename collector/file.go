@@ -0,0 +1,28 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// openStatsFile opens a stats file, failing when it was last modified more
+// than maxAge ago. The modification time is returned in either case.
+func openStatsFile(file string, maxAge time.Duration) (io.ReadCloser, time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, time.Time{}, &scrapeError{reason: "file_error", err: &ErrFetchFailed{Err: err}}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, &scrapeError{reason: "file_error", err: &ErrFetchFailed{Err: err}}
+	}
+	mtime := info.ModTime()
+	if maxAge > 0 && time.Since(mtime) > maxAge {
+		f.Close()
+		return nil, mtime, &scrapeError{reason: "stale", err: fmt.Errorf("stats file %s not modified for %s", file, time.Since(mtime).Round(time.Second))}
+	}
+	return f, mtime, nil
+}
@@ -0,0 +1,384 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// Logger receives the collector's log messages, *logrus.Logger satisfies it
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger discards all messages
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// options configures a Collector, the defaults scrape /stats of the demo
+// server with the default mapping
+type options struct {
+	client *http.Client
+	// mapping of stats fields to metrics
+	mapping *Mapping
+	// namespace and constLabels apply to every metric of the collector
+	namespace   string
+	constLabels prometheus.Labels
+	// timeout of a target request, 0 leaves the client's timeout
+	timeout time.Duration
+	// statsPath replaces /stats as the default path of the metrics
+	statsPath   string
+	strictJSON  bool
+	requireJSON bool
+	onNull      string
+	headers     http.Header
+	userAgent   string
+	disableGzip bool
+	// failureThreshold consecutive failures suspend fetches for failureCooldown, 0 disables
+	failureThreshold int
+	failureCooldown  time.Duration
+	include          []string
+	emitRates        bool
+	// credentials, the files are read on every scrape
+	bearerTokenFile       string
+	basicAuthUser         string
+	basicAuthPasswordFile string
+	maxBodyBytes          int64
+	upRequiresAllPaths    bool
+	upMeansReachable      bool
+	keepLastScrapes       bool
+	traceparent           bool
+	instanceID            string
+	statsRequest          *endpointRequest
+	fileMaxAge            time.Duration
+	startTime             time.Time
+	logger                Logger
+	// fetchers replace the sources of paths
+	fetchers map[string]Fetcher
+}
+
+// Option configures a Collector created by NewCollector
+type Option func(*options) error
+
+// WithHTTPClient fetches the target with client, which is copied so
+// instrumenting it leaves the caller's client untouched
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) error {
+		if client == nil {
+			return errors.New("http client must not be nil")
+		}
+		o.client = client
+		return nil
+	}
+}
+
+// WithMapping maps stats fields to metrics instead of DefaultMapping
+func WithMapping(mapping *Mapping) Option {
+	return func(o *options) error {
+		if mapping == nil {
+			return errors.New("mapping must not be nil")
+		}
+		o.mapping = mapping
+		return nil
+	}
+}
+
+// WithNamespace prefixes the names of all metrics with namespace
+func WithNamespace(namespace string) Option {
+	return func(o *options) error {
+		if namespace == "" || strings.Contains(namespace, ":") || !model.IsValidMetricName(model.LabelValue(namespace)) {
+			return fmt.Errorf("invalid namespace %q", namespace)
+		}
+		o.namespace = namespace
+		return nil
+	}
+}
+
+// WithConstLabels adds labels to all metrics
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) error {
+		for name := range labels {
+			if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+				return fmt.Errorf("invalid label name %q", name)
+			}
+		}
+		o.constLabels = labels
+		return nil
+	}
+}
+
+// WithTimeout bounds each request to the target
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, got %s", timeout)
+		}
+		o.timeout = timeout
+		return nil
+	}
+}
+
+// WithStatsPath fetches path instead of /stats for the metrics and
+// endpoints that do not name another path
+func WithStatsPath(path string) Option {
+	return func(o *options) error {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("stats path %q must start with /", path)
+		}
+		o.statsPath = path
+		return nil
+	}
+}
+
+// WithLogger sends log messages to logger, they are discarded otherwise
+func WithLogger(logger Logger) Option {
+	return func(o *options) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithFetcher sources the stats of path from fetcher, which replaces the
+// request, command or file configured for it
+func WithFetcher(path string, fetcher Fetcher) Option {
+	return func(o *options) error {
+		if fetcher == nil {
+			return fmt.Errorf("fetcher of path %s must not be nil", path)
+		}
+		if o.fetchers == nil {
+			o.fetchers = map[string]Fetcher{}
+		}
+		o.fetchers[path] = fetcher
+		return nil
+	}
+}
+
+// WithStrictJSON fails scrapes of responses with unmapped fields
+func WithStrictJSON(strict bool) Option {
+	return func(o *options) error {
+		o.strictJSON = strict
+		return nil
+	}
+}
+
+// WithRequireJSON fails scrapes of responses without an application/json Content-Type
+func WithRequireJSON(require bool) Option {
+	return func(o *options) error {
+		o.requireJSON = require
+		return nil
+	}
+}
+
+// WithOnNull sets the default null policy: skip, zero or nan. Empty keeps zero.
+func WithOnNull(policy string) Option {
+	return func(o *options) error {
+		switch policy {
+		case "":
+		case onNullSkip, onNullZero, onNullNaN:
+			o.onNull = policy
+		default:
+			return fmt.Errorf("invalid null policy %q, expected skip, zero or nan", policy)
+		}
+		return nil
+	}
+}
+
+// WithHeaders adds headers to target requests, a Host entry overrides the request host
+func WithHeaders(headers http.Header) Option {
+	return func(o *options) error {
+		o.headers = http.Header{}
+		for name, values := range headers {
+			if !ValidHeaderName(name) {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+			o.headers[http.CanonicalHeaderKey(name)] = values
+		}
+		return nil
+	}
+}
+
+// WithUserAgent sends userAgent with target requests
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) error {
+		o.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithDisableGzip stops requesting gzip compressed responses
+func WithDisableGzip(disable bool) Option {
+	return func(o *options) error {
+		o.disableGzip = disable
+		return nil
+	}
+}
+
+// WithCircuitBreaker suspends fetches for cooldown after threshold
+// consecutive failures, a threshold of 0 disables it
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(o *options) error {
+		if threshold < 0 || cooldown < 0 {
+			return errors.New("failure threshold and cooldown must not be negative")
+		}
+		o.failureThreshold, o.failureCooldown = threshold, cooldown
+		return nil
+	}
+}
+
+// WithInclude limits the exposed metrics to names, all metrics are exposed when empty
+func WithInclude(names ...string) Option {
+	return func(o *options) error {
+		o.include = names
+		return nil
+	}
+}
+
+// WithRates exposes a <name>_per_second gauge next to every counter, the
+// rate between the last two scrapes
+func WithRates(enabled bool) Option {
+	return func(o *options) error {
+		o.emitRates = enabled
+		return nil
+	}
+}
+
+// WithBearerTokenFile authenticates target requests with the token in file
+func WithBearerTokenFile(file string) Option {
+	return func(o *options) error {
+		o.bearerTokenFile = file
+		return nil
+	}
+}
+
+// WithBasicAuth authenticates target requests as user with the password in passwordFile
+func WithBasicAuth(user, passwordFile string) Option {
+	return func(o *options) error {
+		o.basicAuthUser, o.basicAuthPasswordFile = user, passwordFile
+		return nil
+	}
+}
+
+// WithMaxBodyBytes limits target responses, stats files and command output,
+// 0 keeps DefaultMaxBodyBytes
+func WithMaxBodyBytes(max int64) Option {
+	return func(o *options) error {
+		if max < 0 {
+			return errors.New("maximum body size must not be negative")
+		}
+		if max > 0 {
+			o.maxBodyBytes = max
+		}
+		return nil
+	}
+}
+
+// WithUpRequiresAllPaths reports the target down when any path fails
+func WithUpRequiresAllPaths(all bool) Option {
+	return func(o *options) error {
+		o.upRequiresAllPaths = all
+		return nil
+	}
+}
+
+// WithStatsRequest requests the stats path with method, sending body as
+// contentType, application/json when empty. GET without body is the default.
+func WithStatsRequest(method string, body []byte, contentType string) Option {
+	return func(o *options) error {
+		method = strings.ToUpper(method)
+		if method == "" {
+			method = http.MethodGet
+		}
+		if !ValidHeaderName(method) {
+			return fmt.Errorf("invalid method %q", method)
+		}
+		if len(body) > 0 && (method == http.MethodGet || method == http.MethodHead) {
+			return fmt.Errorf("%s requests cannot have a body", method)
+		}
+		if len(body) == 0 && method == http.MethodGet {
+			// the default, which the mapping may change
+			o.statsRequest = nil
+			return nil
+		}
+		if len(body) == 0 {
+			body, contentType = nil, ""
+		} else if contentType == "" {
+			contentType = "application/json"
+		}
+		o.statsRequest = &endpointRequest{
+			method:      method,
+			body:        body,
+			contentType: contentType,
+			format:      formatJSON,
+			parser:      jsonParser{},
+		}
+		return nil
+	}
+}
+
+// WithInstanceID adds an instance_id label with id to the collector's own
+// metrics, leaving the metrics of the target without it
+func WithInstanceID(id string) Option {
+	return func(o *options) error {
+		o.instanceID = id
+		return nil
+	}
+}
+
+// WithTraceparent sends a W3C traceparent with every target request and
+// attaches its trace ID as exemplar to the request metrics
+func WithTraceparent(enabled bool) Option {
+	return func(o *options) error {
+		o.traceparent = enabled
+		return nil
+	}
+}
+
+// WithUpMeansReachable keeps paths answering with a bad status up, reporting
+// the status in httpserver_target_unhealthy instead
+func WithUpMeansReachable(reachable bool) Option {
+	return func(o *options) error {
+		o.upMeansReachable = reachable
+		return nil
+	}
+}
+
+// WithStaleOnFailure leaves the metrics of failed paths out so Prometheus
+// marks their series stale, the default. Disabled, the last values read
+// from a path are reported again while it fails.
+func WithStaleOnFailure(stale bool) Option {
+	return func(o *options) error {
+		o.keepLastScrapes = !stale
+		return nil
+	}
+}
+
+// WithFileMaxAge fails scrapes of file:// targets not modified for maxAge, 0 disables
+func WithFileMaxAge(maxAge time.Duration) Option {
+	return func(o *options) error {
+		o.fileMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithStartTime reports uptime since t instead of the collector's creation
+func WithStartTime(t time.Time) Option {
+	return func(o *options) error {
+		o.startTime = t
+		return nil
+	}
+}
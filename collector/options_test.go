@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInvalidOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		option Option
+	}{
+		{"nil_client", WithHTTPClient(nil)},
+		{"nil_mapping", WithMapping(nil)},
+		{"empty_namespace", WithNamespace("")},
+		{"namespace_with_colon", WithNamespace("app:stats")},
+		{"namespace_with_dash", WithNamespace("my-app")},
+		{"invalid_label", WithConstLabels(prometheus.Labels{"my-label": "x"})},
+		{"reserved_label", WithConstLabels(prometheus.Labels{"__name__": "x"})},
+		{"zero_timeout", WithTimeout(0)},
+		{"relative_stats_path", WithStatsPath("stats")},
+		{"nil_logger", WithLogger(nil)},
+		{"nil_fetcher", WithFetcher(defaultStatsPath, nil)},
+		{"null_policy", WithOnNull("drop")},
+		{"get_with_body", WithStatsRequest(http.MethodGet, []byte("{}"), "")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewCollector("http://options.invalid", tc.option); err == nil {
+				t.Error("invalid option accepted")
+			}
+			defer func() {
+				if recover() == nil {
+					t.Error("MustNewCollector did not panic")
+				}
+			}()
+			MustNewCollector("http://options.invalid", tc.option)
+		})
+	}
+}
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	requests int32
+}
+
+// RoundTrip
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestOptions(t *testing.T) {
+	var requestedPath atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath.Store(r.URL.Path)
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte(`{"http200Requestcounter": 5, "http500Requestcounter": 1}`))
+	}))
+	defer server.Close()
+
+	t.Run("http_client", func(t *testing.T) {
+		transport := &countingTransport{}
+		c := MustNewCollector(server.URL, WithHTTPClient(&http.Client{Transport: transport}))
+		gatherValue(t, c, "httpserver_up")
+		if n := atomic.LoadInt32(&transport.requests); n != 1 {
+			t.Errorf("%d requests through the client, want 1", n)
+		}
+	})
+	t.Run("namespace", func(t *testing.T) {
+		c := MustNewCollector(server.URL, WithNamespace("app"))
+		names := gatherNames(t, c)
+		if !names["app_httpserver_up"] || names["httpserver_up"] {
+			t.Errorf("metrics not prefixed with the namespace: %v", names)
+		}
+	})
+	t.Run("const_labels", func(t *testing.T) {
+		c := MustNewCollector(server.URL, WithConstLabels(prometheus.Labels{"env": "test"}))
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(c)
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, family := range families {
+			labelled := false
+			for _, label := range family.GetMetric()[0].GetLabel() {
+				labelled = labelled || label.GetName() == "env" && label.GetValue() == "test"
+			}
+			if !labelled {
+				t.Errorf("%s misses the env label", family.GetName())
+			}
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		c := MustNewCollector(server.URL, WithStatsPath("/slow"), WithTimeout(10*time.Millisecond))
+		if up := gatherValue(t, c, "httpserver_up"); up != 0 {
+			t.Errorf("httpserver_up = %v for a target slower than the timeout, want 0", up)
+		}
+	})
+	t.Run("stats_path", func(t *testing.T) {
+		c := MustNewCollector(server.URL, WithStatsPath("/custom/stats"))
+		gatherValue(t, c, "httpserver_up")
+		if path := requestedPath.Load(); path != "/custom/stats" {
+			t.Errorf("requested %v, want /custom/stats", path)
+		}
+	})
+	t.Run("logger", func(t *testing.T) {
+		logger := &recordingLogger{}
+		c := MustNewCollector("http://127.0.0.1:1", WithLogger(logger))
+		gatherValue(t, c, "httpserver_up")
+		if len(logger.logged("error")) == 0 {
+			t.Error("failed scrape not logged to the logger")
+		}
+	})
+}
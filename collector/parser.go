@@ -0,0 +1,311 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parser decodes the top-level fields of a stats response
+type Parser interface {
+	// Parse returns the values of the wanted fields found in body and the
+	// names of the fields that are not wanted
+	Parse(body []byte, wanted map[string]bool) (map[string]statValue, []string, error)
+}
+
+// rowParser is implemented by parsers of tabular responses holding one set
+// of fields per row
+type rowParser interface {
+	Parser
+	// ParseRows returns the wanted fields of every well-formed row, the
+	// names of the fields that are not wanted and why malformed rows were
+	// skipped
+	ParseRows(body []byte, wanted map[string]bool) ([]statRow, []string, []error, error)
+	// labelName names the label set from each row, empty when rows are not labelled
+	labelName() string
+}
+
+// statRow is the fields of one row, label is empty for unlabelled rows
+type statRow struct {
+	label string
+	stats map[string]statValue
+}
+
+// jsonParser reads a JSON object, the default format
+type jsonParser struct{}
+
+// Parse
+func (jsonParser) Parse(body []byte, wanted map[string]bool) (map[string]statValue, []string, error) {
+	var payload map[string]jsonField
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, nil, err
+	}
+	stats := make(map[string]statValue, len(wanted))
+	var unknown []string
+	for key, field := range payload {
+		if !wanted[key] {
+			unknown = append(unknown, key)
+			continue
+		}
+		if field.err != nil {
+			return nil, nil, fmt.Errorf("field %q: %w", key, field.err)
+		}
+		stats[key] = field.value
+	}
+	return stats, unknown, nil
+}
+
+// jsonField decodes a top-level field in the same pass as the payload,
+// keeping why it is not a stat value instead of failing the payload since
+// unwanted fields may hold anything
+type jsonField struct {
+	value statValue
+	err   error
+}
+
+// UnmarshalJSON
+func (f *jsonField) UnmarshalJSON(b []byte) error {
+	f.err = f.value.UnmarshalJSON(b)
+	return nil
+}
+
+// expvarParser reads the /debug/vars of a Go service, flattening nested
+// objects such as memstats into fields joined by dots, memstats.HeapAlloc.
+// Arrays and strings are left out.
+type expvarParser struct{}
+
+// Parse
+func (expvarParser) Parse(body []byte, wanted map[string]bool) (map[string]statValue, []string, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, nil, err
+	}
+	stats := make(map[string]statValue, len(wanted))
+	var unknown []string
+	if err := flattenExpvar("", payload, wanted, stats, &unknown); err != nil {
+		return nil, nil, err
+	}
+	return stats, unknown, nil
+}
+
+// flattenExpvar adds the numbers and nulls of object to stats under prefix,
+// descending into nested objects
+func flattenExpvar(prefix string, object map[string]json.RawMessage, wanted map[string]bool, stats map[string]statValue, unknown *[]string) error {
+	for key, raw := range object {
+		name := prefix + key
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		switch raw[0] {
+		case '{':
+			var nested map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &nested); err != nil {
+				return fmt.Errorf("field %q: %w", name, err)
+			}
+			if err := flattenExpvar(name+".", nested, wanted, stats, unknown); err != nil {
+				return err
+			}
+		case '[', '"', 't', 'f':
+			if wanted[name] {
+				return fmt.Errorf("field %q is not a number", name)
+			}
+		default:
+			if !wanted[name] {
+				*unknown = append(*unknown, name)
+				continue
+			}
+			var value statValue
+			if err := value.UnmarshalJSON(raw); err != nil {
+				return fmt.Errorf("field %q: %w", name, err)
+			}
+			stats[name] = value
+		}
+	}
+	return nil
+}
+
+// xmlParser reads the child elements of the root element, such as
+// <stats><requests200>10</requests200></stats>. Empty elements are null.
+type xmlParser struct{}
+
+// Parse
+func (xmlParser) Parse(body []byte, wanted map[string]bool) (map[string]statValue, []string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	stats := make(map[string]statValue, len(wanted))
+	var (
+		unknown []string
+		depth   int
+		field   string
+		text    strings.Builder
+		nested  bool
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 2:
+				field, nested = t.Name.Local, false
+				text.Reset()
+			case 3:
+				nested = true
+			}
+		case xml.CharData:
+			if depth == 2 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				if !wanted[field] {
+					unknown = append(unknown, field)
+				} else {
+					if nested {
+						return nil, nil, fmt.Errorf("field %q: not a value", field)
+					}
+					value, err := parseTextValue(strings.TrimSpace(text.String()))
+					if err != nil {
+						return nil, nil, fmt.Errorf("field %q: %w", field, err)
+					}
+					stats[field] = value
+				}
+			}
+			depth--
+		}
+	}
+	if depth != 0 {
+		return nil, nil, errors.New("unexpected end of XML")
+	}
+	return stats, unknown, nil
+}
+
+// parseTextValue parses a value given as plain text, empty text is null
+func parseTextValue(s string) (statValue, error) {
+	if s == "" {
+		return statValue{null: true}, nil
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return statValue{}, fmt.Errorf("invalid counter value %q", s)
+	}
+	return statValue{raw: json.Number(s)}, nil
+}
+
+// csvParser reads a header row naming the fields followed by rows of values.
+// Without labelColumn the last row holds the current values, with it every
+// row is an entity named by that column.
+type csvParser struct {
+	delimiter   rune
+	labelColumn string
+}
+
+// Parse
+func (p csvParser) Parse(body []byte, wanted map[string]bool) (map[string]statValue, []string, error) {
+	rows, unknown, _, err := p.ParseRows(body, wanted)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return map[string]statValue{}, unknown, nil
+	}
+	return rows[len(rows)-1].stats, unknown, nil
+}
+
+// ParseRows
+func (p csvParser) ParseRows(body []byte, wanted map[string]bool) ([]statRow, []string, []error, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.Comma = p.delimiter
+	// rows of the wrong length are counted as malformed below
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil, errors.New("missing CSV header")
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	labelIndex := -1
+	var unknown []string
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		switch {
+		case p.labelColumn != "" && header[i] == p.labelColumn:
+			labelIndex = i
+		case !wanted[header[i]]:
+			unknown = append(unknown, header[i])
+		}
+	}
+	if p.labelColumn != "" && labelIndex < 0 {
+		return nil, nil, nil, fmt.Errorf("label column %q not in CSV header", p.labelColumn)
+	}
+	var (
+		rows    []statRow
+		skipped []error
+	)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			skipped = append(skipped, err)
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		row, err := p.parseRow(header, record, labelIndex, wanted)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("row %d: %w", len(rows)+len(skipped)+2, err))
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, unknown, skipped, nil
+}
+
+// parseRow
+func (p csvParser) parseRow(header, record []string, labelIndex int, wanted map[string]bool) (statRow, error) {
+	if len(record) != len(header) {
+		return statRow{}, fmt.Errorf("%d columns, expected %d", len(record), len(header))
+	}
+	row := statRow{stats: make(map[string]statValue, len(wanted))}
+	for i, column := range header {
+		if i == labelIndex {
+			row.label = strings.TrimSpace(record[i])
+			// label values must be valid UTF-8 to be exposed
+			if !utf8.ValidString(row.label) {
+				return statRow{}, fmt.Errorf("column %q: label value is not valid UTF-8", column)
+			}
+			continue
+		}
+		if !wanted[column] {
+			continue
+		}
+		value, err := parseTextValue(strings.TrimSpace(record[i]))
+		if err != nil {
+			return statRow{}, fmt.Errorf("column %q: %w", column, err)
+		}
+		row.stats[column] = value
+	}
+	return row, nil
+}
+
+// labelName
+func (p csvParser) labelName() string {
+	return p.labelColumn
+}
@@ -0,0 +1,365 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fuzzMapping reads the same fuzzed body in every format
+const fuzzMapping = `
+metrics:
+  - {name: json_requests, path: /json, field: http200Requestcounter}
+  - {name: json_errors, path: /json, field: http500Requestcounter, type: gauge}
+  - {name: xml_requests, path: /xml, field: requests}
+  - {name: csv_requests, path: /csv, field: requests}
+  - {name: csv_row_requests, path: /rows, field: requests}
+  - {name: expvar_heap, path: /expvar, field: memstats.HeapAlloc, type: gauge}
+endpoints:
+  - {path: /xml, format: xml}
+  - {path: /csv, format: csv}
+  - {path: /rows, format: csv, label_column: name}
+  - {path: /expvar, format: expvar}
+`
+
+// fuzzFetcher returns the body of the current fuzz input for every path
+type fuzzFetcher struct {
+	body *[]byte
+}
+
+// Fetch
+func (f fuzzFetcher) Fetch(context.Context) ([]byte, FetchInfo, error) {
+	return *f.body, FetchInfo{}, nil
+}
+
+func FuzzParseStats(f *testing.F) {
+	for _, seed := range []string{
+		// the demo server's /stats
+		`{"http200Requestcounter": 5, "http500Requestcounter": 1}`,
+		`{"http200Requestcounter": null, "http500Requestcounter": 1e400}`,
+		`{"http200Requestcounter": 18446744073709551616, "ключ": [null, null], "a": {"b": {"c": {"d": [[[]]]}}}}`,
+		`{"http200Requestcounter": "5", "http500Requestcounter": -1}`,
+		`<stats><requests>10</requests><errors/></stats>`,
+		`<stats><requests>1e999</requests><nested><requests>2</requests></nested></stats>`,
+		"requests,errors\n10,1\n11,2\n",
+		"name,requests\nfront,10\nback,\n\xff,3\n",
+		`{"memstats": {"HeapAlloc": 1024, "BySize": [{"Size": 0}]}, "cmdline": ["exporter"]}`,
+		``,
+		`[]`,
+	} {
+		f.Add([]byte(seed))
+	}
+	mappingFile := filepath.Join(f.TempDir(), "metrics.yml")
+	if err := os.WriteFile(mappingFile, []byte(fuzzMapping), 0644); err != nil {
+		f.Fatal(err)
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		f.Fatal(err)
+	}
+	var body []byte
+	opts := []Option{WithMapping(mapping)}
+	for _, path := range []string{"/json", "/xml", "/csv", "/rows", "/expvar"} {
+		opts = append(opts, WithFetcher(path, fuzzFetcher{&body}))
+	}
+	c, err := NewCollector("http://fuzz.invalid", opts...)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		body = input
+		for _, path := range c.paths {
+			scrape, _, err := c.fetchStats(context.Background(), path)
+			var parseErr *ErrParse
+			switch {
+			case err != nil && !errors.As(err, &parseErr):
+				t.Fatalf("path %s: error %v is not an ErrParse", path, err)
+			case err == nil && scrape.stats == nil && scrape.rows == nil && !scrape.labelled:
+				t.Fatalf("path %s: neither values nor an error", path)
+			}
+		}
+		// the mapping layer recovers panics, which must not happen either
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(c)
+		registry.Gather()
+		if panics := testutil.ToFloat64(c.panics); panics != 0 {
+			t.Fatalf("collecting %q panicked", input)
+		}
+	})
+}
+
+// statStrings renders stats as their raw values, null as "null", to compare
+// them in tests
+func statStrings(stats map[string]statValue) map[string]string {
+	out := make(map[string]string, len(stats))
+	for key, v := range stats {
+		out[key] = string(v.raw)
+		if v.null {
+			out[key] = "null"
+		}
+	}
+	return out
+}
+
+// parserCase is a body parsed for the wanted fields, failing with an error
+// containing err when set
+type parserCase struct {
+	name    string
+	body    string
+	want    map[string]string
+	unknown []string
+	err     string
+}
+
+// runParserCases
+func runParserCases(t *testing.T, parser Parser, wanted map[string]bool, cases []parserCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats, unknown, err := parser.Parse([]byte(tc.body), wanted)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want one containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := statStrings(stats); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("stats = %v, want %v", got, tc.want)
+			}
+			sort.Strings(unknown)
+			if !reflect.DeepEqual(unknown, tc.unknown) {
+				t.Errorf("unknown = %v, want %v", unknown, tc.unknown)
+			}
+		})
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	runParserCases(t, jsonParser{}, map[string]bool{"requests": true, "errors": true}, []parserCase{
+		{name: "numbers", body: `{"requests": 10, "errors": 1.5}`, want: map[string]string{"requests": "10", "errors": "1.5"}},
+		{name: "null_and_quoted", body: `{"requests": null, "errors": "7"}`, want: map[string]string{"requests": "null", "errors": "7"}},
+		{name: "missing_field", body: `{"requests": 10}`, want: map[string]string{"requests": "10"}},
+		{
+			name:    "unknown_fields_may_hold_anything",
+			body:    `{"requests": 10, "uptime": "1h", "build": {"version": [1, 2]}}`,
+			want:    map[string]string{"requests": "10"},
+			unknown: []string{"build", "uptime"},
+		},
+		{name: "wanted_field_not_a_number", body: `{"requests": true}`, err: `field "requests"`},
+		{name: "wanted_field_invalid_string", body: `{"requests": "ten"}`, err: `invalid counter value "ten"`},
+		{name: "not_an_object", body: `[1, 2]`, err: "cannot unmarshal"},
+		{name: "truncated", body: `{"requests": 10`, err: "unexpected end"},
+	})
+}
+
+func TestXMLParser(t *testing.T) {
+	runParserCases(t, xmlParser{}, map[string]bool{"requests": true, "errors": true}, []parserCase{
+		{name: "values", body: `<stats><requests>10</requests><errors> 2 </errors></stats>`, want: map[string]string{"requests": "10", "errors": "2"}},
+		{name: "declaration_and_attributes", body: `<?xml version="1.0"?><stats host="a"><requests unit="1">10</requests></stats>`, want: map[string]string{"requests": "10"}},
+		{name: "empty_is_null", body: `<stats><requests/><errors></errors></stats>`, want: map[string]string{"requests": "null", "errors": "null"}},
+		{
+			name:    "unknown_and_nested_unknown",
+			body:    `<stats><requests>10</requests><build><version>1</version></build><uptime>5</uptime></stats>`,
+			want:    map[string]string{"requests": "10"},
+			unknown: []string{"build", "uptime"},
+		},
+		{name: "deeper_elements_are_ignored", body: `<stats><nested><requests>2</requests></nested></stats>`, want: map[string]string{}, unknown: []string{"nested"}},
+		{name: "wanted_field_nested", body: `<stats><requests><total>10</total></requests></stats>`, err: `field "requests": not a value`},
+		{name: "wanted_field_not_a_number", body: `<stats><requests>ten</requests></stats>`, err: `invalid counter value "ten"`},
+		{name: "unclosed", body: `<stats><requests>10</requests>`, err: "unexpected EOF"},
+		{name: "mismatched", body: `<stats><requests>10</errors></stats>`, err: "element <requests> closed by </errors>"},
+	})
+}
+
+func TestCSVParser(t *testing.T) {
+	wanted := map[string]bool{"requests": true, "errors": true}
+	runParserCases(t, csvParser{delimiter: ','}, wanted, []parserCase{
+		{name: "last_row_is_current", body: "requests,errors\n10,1\n12,2\n", want: map[string]string{"requests": "12", "errors": "2"}},
+		{name: "spaces_and_empty_null", body: " requests , errors \n 10 , \n", want: map[string]string{"requests": "10", "errors": "null"}},
+		{name: "header_only", body: "requests,errors\n", want: map[string]string{}},
+		{name: "unknown_columns", body: "time,requests,uptime\n12:00,10,5\n", want: map[string]string{"requests": "10"}, unknown: []string{"time", "uptime"}},
+		{name: "malformed_last_row_is_skipped", body: "requests,errors\n10,1\n11\n", want: map[string]string{"requests": "10", "errors": "1"}},
+		{name: "empty", body: "", err: "missing CSV header"},
+	})
+	runParserCases(t, csvParser{delimiter: ';'}, wanted, []parserCase{
+		{name: "delimiter", body: "requests;errors\n10;1\n", want: map[string]string{"requests": "10", "errors": "1"}},
+	})
+}
+
+func TestCSVParseRows(t *testing.T) {
+	wanted := map[string]bool{"requests": true}
+	for _, tc := range []struct {
+		name    string
+		parser  csvParser
+		body    string
+		rows    map[string]string
+		skipped int
+		err     string
+	}{
+		{
+			name:   "labelled",
+			parser: csvParser{delimiter: ',', labelColumn: "name"},
+			body:   "name,requests\nfront,10\nback,\n",
+			rows:   map[string]string{"front": "10", "back": "null"},
+		},
+		{
+			name:    "malformed_rows_are_skipped",
+			parser:  csvParser{delimiter: ',', labelColumn: "name"},
+			body:    "name,requests\nfront,10\nshort\nback,ten\n\"open,1\nlast,3\n",
+			rows:    map[string]string{"front": "10"},
+			skipped: 3,
+		},
+		{
+			name:    "invalid_utf8_label",
+			parser:  csvParser{delimiter: ',', labelColumn: "name"},
+			body:    "name,requests\n\xff,1\nok,2\n",
+			rows:    map[string]string{"ok": "2"},
+			skipped: 1,
+		},
+		{
+			name:   "missing_label_column",
+			parser: csvParser{delimiter: ',', labelColumn: "name"},
+			body:   "host,requests\na,1\n",
+			err:    `label column "name" not in CSV header`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, _, skipped, err := tc.parser.ParseRows([]byte(tc.body), wanted)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want one containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, row := range rows {
+				got[row.label] = statStrings(row.stats)["requests"]
+			}
+			if !reflect.DeepEqual(got, tc.rows) {
+				t.Errorf("rows = %v, want %v", got, tc.rows)
+			}
+			if len(skipped) != tc.skipped {
+				t.Errorf("skipped %v, want %d rows", skipped, tc.skipped)
+			}
+		})
+	}
+}
+
+func TestNewCSVParser(t *testing.T) {
+	for _, tc := range []struct {
+		ep    endpointConfig
+		valid bool
+	}{
+		{endpointConfig{}, true},
+		{endpointConfig{Delimiter: ";"}, true},
+		{endpointConfig{Delimiter: "\t", LabelColumn: "name"}, true},
+		{endpointConfig{Delimiter: ";;"}, false},
+		{endpointConfig{Delimiter: `"`}, false},
+		{endpointConfig{Delimiter: "\n"}, false},
+		{endpointConfig{LabelColumn: "0name"}, false},
+	} {
+		if _, err := newCSVParser(tc.ep); (err == nil) != tc.valid {
+			t.Errorf("newCSVParser(%+v) error = %v, want valid %v", tc.ep, err, tc.valid)
+		}
+	}
+}
+
+func TestCollectLabelledCSV(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "metrics.yml")
+	if err := os.WriteFile(mappingFile, []byte(`
+metrics:
+  - {name: backend_requests_total, help: Requests by backend., path: /backends, field: requests}
+endpoints:
+  - {path: /backends, format: csv, delimiter: ";", label_column: backend}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCollector("http://csv.invalid", WithMapping(mapping),
+		WithFetcher("/backends", StaticFetcher{Body: []byte("backend;requests\nfront;10\nback;4\nbroken;x\n")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP backend_requests_total Requests by backend.
+# TYPE backend_requests_total counter
+backend_requests_total{backend="back"} 4
+backend_requests_total{backend="front"} 10
+# HELP exporter_malformed_rows_total Number of malformed rows skipped in the target's CSV stats.
+# TYPE exporter_malformed_rows_total counter
+exporter_malformed_rows_total 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "backend_requests_total", "exporter_malformed_rows_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExpvarParser(t *testing.T) {
+	wanted := map[string]bool{"memstats.HeapAlloc": true, "memstats.GC.NumGC": true, "requests": true}
+	runParserCases(t, expvarParser{}, wanted, []parserCase{
+		{
+			name:    "nested_objects_are_joined_by_dots",
+			body:    `{"memstats": {"HeapAlloc": 1024, "Sys": 4096, "GC": {"NumGC": 3}}, "requests": 7}`,
+			want:    map[string]string{"memstats.HeapAlloc": "1024", "memstats.GC.NumGC": "3", "requests": "7"},
+			unknown: []string{"memstats.Sys"},
+		},
+		{
+			name: "arrays_strings_and_bools_are_left_out",
+			body: `{"cmdline": ["exporter", "-v"], "version": "1.2", "debug": false, "memstats": {"BySize": [{"Size": 0}], "HeapAlloc": 1}}`,
+			want: map[string]string{"memstats.HeapAlloc": "1"},
+		},
+		{name: "null", body: `{"requests": null}`, want: map[string]string{"requests": "null"}},
+		{name: "wanted_field_not_a_number", body: `{"requests": "7"}`, err: `field "requests" is not a number`},
+		{name: "wanted_field_an_object_leaf", body: `{"memstats": {"HeapAlloc": [1]}}`, err: `field "memstats.HeapAlloc" is not a number`},
+		{name: "not_an_object", body: `[]`, err: "cannot unmarshal"},
+	})
+}
+
+func TestCollectExpvar(t *testing.T) {
+	server := httptest.NewServer(expvar.Handler())
+	defer server.Close()
+	mappingFile := filepath.Join(t.TempDir(), "metrics.yml")
+	if err := os.WriteFile(mappingFile, []byte(`
+metrics:
+  - {name: go_heap_alloc_bytes, path: /debug/vars, field: memstats.HeapAlloc, type: gauge}
+  - {name: go_gc_cycles_total, path: /debug/vars, field: memstats.NumGC}
+endpoints:
+  - {path: /debug/vars, format: expvar}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := LoadMapping(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCollector(server.URL, WithMapping(mapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heap := gatherValue(t, c, "go_heap_alloc_bytes"); heap <= 0 {
+		t.Errorf("go_heap_alloc_bytes = %v, want the test's heap size", heap)
+	}
+	if n := testutil.CollectAndCount(c, "go_gc_cycles_total"); n != 1 {
+		t.Errorf("%d go_gc_cycles_total samples, want 1", n)
+	}
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateSuffix names the derived per-second gauge of a counter
+const rateSuffix = "_per_second"
+
+// rateTracker derives per-second rates of counters from consecutive scrapes
+type rateTracker struct {
+	mu   sync.Mutex
+	last map[string]rateSample
+}
+
+type rateSample struct {
+	value float64
+	at    time.Time
+}
+
+// newRateTracker
+func newRateTracker() *rateTracker {
+	return &rateTracker{last: map[string]rateSample{}}
+}
+
+// observe records the value of a series at t and returns its rate since the
+// previous observation, 0 after a counter reset, which is reported as
+// decreased. There is no rate on the first observation.
+func (r *rateTracker) observe(series string, value float64, at time.Time) (rate float64, ok, decreased bool) {
+	if math.IsNaN(value) {
+		return 0, false, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev, ok := r.last[series]
+	r.last[series] = rateSample{value: value, at: at}
+	elapsed := at.Sub(prev.at).Seconds()
+	if !ok || elapsed <= 0 {
+		return 0, false, ok && value < prev.value
+	}
+	if value < prev.value {
+		return 0, true, true
+	}
+	return (value - prev.value) / elapsed, true, false
+}
@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateTrackerObserve(t *testing.T) {
+	type observation struct {
+		value     float64
+		offset    time.Duration
+		rate      float64
+		ok        bool
+		decreased bool
+	}
+	start := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		name         string
+		observations []observation
+	}{
+		{"first_has_no_rate", []observation{
+			{value: 10},
+		}},
+		{"known_deltas", []observation{
+			{value: 10},
+			{value: 60, offset: 5 * time.Second, rate: 10, ok: true},
+			{value: 60, offset: 10 * time.Second, rate: 0, ok: true},
+			{value: 90, offset: 25 * time.Second, rate: 2, ok: true},
+		}},
+		{"counter_reset", []observation{
+			{value: 100},
+			{value: 4, offset: 5 * time.Second, rate: 0, ok: true, decreased: true},
+			{value: 14, offset: 10 * time.Second, rate: 2, ok: true},
+		}},
+		{"same_time", []observation{
+			{value: 1},
+			{value: 5},
+			{value: 3, decreased: true},
+		}},
+		{"clock_backwards", []observation{
+			{value: 1, offset: 10 * time.Second},
+			{value: 5},
+		}},
+		{"nan_is_skipped", []observation{
+			{value: 10},
+			{value: math.NaN(), offset: 5 * time.Second},
+			{value: 20, offset: 10 * time.Second, rate: 1, ok: true},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRateTracker()
+			for i, o := range tc.observations {
+				rate, ok, decreased := r.observe("series", o.value, start.Add(o.offset))
+				if rate != o.rate || ok != o.ok || decreased != o.decreased {
+					t.Errorf("observation %d: observe(%v) = %v, %v, %v, want %v, %v, %v",
+						i, o.value, rate, ok, decreased, o.rate, o.ok, o.decreased)
+				}
+			}
+		})
+	}
+}
+
+func TestRateTrackerSeriesAreIndependent(t *testing.T) {
+	r := newRateTracker()
+	start := time.Unix(1700000000, 0)
+	r.observe("a", 0, start)
+	r.observe("b", 100, start)
+	if rate, _, _ := r.observe("a", 10, start.Add(time.Second)); rate != 10 {
+		t.Errorf("rate of a = %v, want 10", rate)
+	}
+	if rate, _, _ := r.observe("b", 150, start.Add(5*time.Second)); rate != 10 {
+		t.Errorf("rate of b = %v, want 10", rate)
+	}
+}
+
+func TestCollectRates(t *testing.T) {
+	var counter int64 = 100
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"http200Requestcounter": ` + strconv.FormatInt(atomic.LoadInt64(&counter), 10) + `}`))
+	}))
+	defer server.Close()
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"disabled", false},
+		{"enabled", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt64(&counter, 100)
+			c, err := NewCollector(server.URL, WithRates(tc.enabled))
+			if err != nil {
+				t.Fatal(err)
+			}
+			const name = "http_request_200counter_per_second"
+			before := time.Now()
+			if gatherNames(t, c)[name] {
+				t.Fatalf("%s exposed after the first scrape", name)
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt64(&counter, 150)
+			if !tc.enabled {
+				if gatherNames(t, c)[name] {
+					t.Errorf("%s exposed with rates disabled", name)
+				}
+				return
+			}
+			rate := gatherValue(t, c, name)
+			// 50 over at least 50ms and at most the time since the first scrape
+			if maxRate, minRate := 50/0.05, 50/time.Since(before).Seconds(); rate > maxRate || rate < minRate {
+				t.Errorf("rate = %v, want between %v and %v", rate, minRate, maxRate)
+			}
+			// a reset reports 0 for the cycle
+			atomic.StoreInt64(&counter, 3)
+			if rate := gatherValue(t, c, name); rate != 0 {
+				t.Errorf("rate after a reset = %v, want 0", rate)
+			}
+		})
+	}
+}
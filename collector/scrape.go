@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// PathResult is what fetching and decoding a path of the target returned
+type PathResult struct {
+	Path string
+	// StatusCode of the HTTP response, 0 for other sources and connection errors
+	StatusCode int
+	Duration   time.Duration
+	// Stats of JSON and unlabelled CSV paths as sent by the target
+	Stats map[string]json.RawMessage
+	// Rows of labelled CSV paths by label value
+	Rows map[string]map[string]json.RawMessage
+	// Families names the metric families of Prometheus paths
+	Families []string
+	Err      error
+}
+
+// Scrape fetches and decodes every path of the target once, outside of a
+// scrape, for inspecting what the target returns. It leaves readiness, the
+// circuit breaker, up and the scrape error metrics alone, but its requests
+// are observed like any other: the request and response status metrics,
+// the response age, TLS certificate and stats file metrics and the count of
+// unknown fields include them.
+func (e *Collector) Scrape(ctx context.Context) []PathResult {
+	results := make([]PathResult, 0, len(e.paths))
+	for _, path := range e.paths {
+		start := time.Now()
+		scrape, info, err := e.fetchStats(ctx, path)
+		result := PathResult{Path: path, StatusCode: info.StatusCode, Duration: info.Duration, Err: err}
+		if result.Duration == 0 {
+			result.Duration = time.Since(start)
+		}
+		if err == nil {
+			result.Stats = rawStats(scrape.stats)
+			if scrape.labelled {
+				result.Rows = map[string]map[string]json.RawMessage{}
+				for _, row := range scrape.rows {
+					result.Rows[row.label] = rawStats(row.stats)
+				}
+			}
+			for _, family := range scrape.families {
+				result.Families = append(result.Families, family.GetName())
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// rawStats
+func rawStats(stats map[string]statValue) map[string]json.RawMessage {
+	if stats == nil {
+		return nil
+	}
+	raw := make(map[string]json.RawMessage, len(stats))
+	for name, value := range stats {
+		// statValue always marshals
+		raw[name], _ = value.MarshalJSON()
+	}
+	return raw
+}
@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// traceIDKey holds the trace ID of a target request in its context
+type traceIDKey struct{}
+
+// startTrace sends a new W3C traceparent with request, keeping a traceparent
+// set through the configured headers, and returns the request carrying the
+// trace ID for the exemplars of the request metrics
+func startTrace(request *http.Request) *http.Request {
+	traceID := request.Header.Get("traceparent")
+	if traceID == "" {
+		var ids [24]byte
+		if _, err := rand.Read(ids[:]); err != nil {
+			return request
+		}
+		traceID = hex.EncodeToString(ids[:16])
+		request.Header.Set("traceparent", "00-"+traceID+"-"+hex.EncodeToString(ids[16:])+"-01")
+	} else if traceID = traceparentID(traceID); traceID == "" {
+		return request
+	}
+	return request.WithContext(context.WithValue(request.Context(), traceIDKey{}, traceID))
+}
+
+// traceparentID returns the trace ID of a traceparent header, empty when it is invalid
+func traceparentID(traceparent string) string {
+	// version-traceid-parentid-flags
+	if len(traceparent) < 55 || traceparent[2] != '-' || traceparent[35] != '-' {
+		return ""
+	}
+	if _, err := hex.DecodeString(traceparent[3:35]); err != nil {
+		return ""
+	}
+	return traceparent[3:35]
+}
+
+// traceExemplar labels the request metrics with the trace ID of the request
+func traceExemplar(ctx context.Context) prometheus.Labels {
+	if traceID, ok := ctx.Value(traceIDKey{}).(string); ok {
+		return prometheus.Labels{"trace_id": traceID}
+	}
+	return nil
+}
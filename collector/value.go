@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// statValue keeps a counter as sent by the target and only converts it
+// to float64 when the sample is emitted
+type statValue struct {
+	raw  json.Number
+	null bool
+}
+
+// UnmarshalJSON
+func (v *statValue) UnmarshalJSON(b []byte) error {
+	*v = statValue{}
+	if string(b) == "null" {
+		v.null = true
+		return nil
+	}
+	// quoted values such as "NaN" or "12"
+	var s string
+	if len(b) > 0 && b[0] == '"' && json.Unmarshal(b, &s) == nil {
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return fmt.Errorf("invalid counter value %q", s)
+		}
+		v.raw = json.Number(s)
+		return nil
+	}
+	// b is a single valid JSON value, a number when it starts like one
+	if len(b) > 0 && (b[0] == '-' || b[0] >= '0' && b[0] <= '9') {
+		v.raw = json.Number(b)
+		return nil
+	}
+	return json.Unmarshal(b, &v.raw)
+}
+
+// MarshalJSON
+func (v statValue) MarshalJSON() ([]byte, error) {
+	if v.null {
+		return []byte("null"), nil
+	}
+	if v.raw == "" {
+		return []byte("0"), nil
+	}
+	if f := v.Float64(); math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte(strconv.Quote(string(v.raw))), nil
+	}
+	return []byte(v.raw), nil
+}
+
+// Float64 converts the value for emission
+func (v statValue) Float64() float64 {
+	if v.raw == "" {
+		// missing fields count as 0
+		return 0
+	}
+	f, _ := v.raw.Float64()
+	return f
+}
+
+// losesPrecision reports whether the value is an integer counter too large
+// to be represented exactly as float64
+func (v statValue) losesPrecision() bool {
+	if len(v.raw) < len("9007199254740993") {
+		// shorter values, missing ones included, are below 2^53
+		return false
+	}
+	u, err := strconv.ParseUint(string(v.raw), 10, 64)
+	return err == nil && u > maxExactFloat && uint64(float64(u)) != u
+}
@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+)
+
+func TestStatValueUnmarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    float64
+		null    bool
+		lossy   bool
+		invalid bool
+	}{
+		{in: `0`, want: 0},
+		{in: `1234`, want: 1234},
+		{in: `-3.5`, want: -3.5},
+		{in: `1e3`, want: 1000},
+		{in: `null`, null: true},
+		{in: `"12"`, want: 12},
+		{in: `"NaN"`, want: math.NaN()},
+		{in: `"+Inf"`, want: math.Inf(1)},
+		{in: `9007199254740992`, want: 1 << 53},
+		{in: `9007199254740993`, want: 1 << 53, lossy: true},
+		{in: `18446744073709551615`, want: 18446744073709551615, lossy: true},
+		// beyond uint64, precision is lost but not reported
+		{in: `18446744073709551616`, want: 18446744073709551616},
+		{in: `"twelve"`, invalid: true},
+		{in: `true`, invalid: true},
+		{in: `{}`, invalid: true},
+	} {
+		var v statValue
+		err := v.UnmarshalJSON([]byte(tc.in))
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%s: decoded as %q, want an error", tc.in, v.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if v.null != tc.null {
+			t.Errorf("%s: null = %v, want %v", tc.in, v.null, tc.null)
+		}
+		if got := v.Float64(); got != tc.want && !(math.IsNaN(got) && math.IsNaN(tc.want)) {
+			t.Errorf("%s: Float64() = %v, want %v", tc.in, got, tc.want)
+		}
+		if got := v.losesPrecision(); got != tc.lossy {
+			t.Errorf("%s: losesPrecision() = %v, want %v", tc.in, got, tc.lossy)
+		}
+	}
+}
+
+func TestStatValueWithinOneULP(t *testing.T) {
+	// the emitted float64 is the nearest to the exact counter
+	for _, exact := range []uint64{1<<53 + 1, 1<<60 + 12345, math.MaxUint64 - 1} {
+		var v statValue
+		raw := strconv.FormatUint(exact, 10)
+		if err := v.UnmarshalJSON([]byte(raw)); err != nil {
+			t.Fatal(err)
+		}
+		got := v.Float64()
+		ulp := math.Nextafter(got, math.Inf(1)) - got
+		diff, _ := new(big.Float).Sub(big.NewFloat(got), new(big.Float).SetUint64(exact)).Float64()
+		if math.Abs(diff) > ulp {
+			t.Errorf("%s emitted as %v, off by %v, more than 1 ULP %v", raw, got, diff, ulp)
+		}
+	}
+}
+
+func TestStatValueMarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		value statValue
+		want  string
+	}{
+		{statValue{}, `0`},
+		{statValue{null: true}, `null`},
+		{statValue{raw: "18446744073709551615"}, `18446744073709551615`},
+		{statValue{raw: "NaN"}, `"NaN"`},
+		{statValue{raw: "-Inf"}, `"-Inf"`},
+	} {
+		got, err := tc.value.MarshalJSON()
+		if err != nil || string(got) != tc.want {
+			t.Errorf("MarshalJSON(%+v) = %s, %v, want %s", tc.value, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package demoserver serves the demo endpoints whose stats the exporter reads
+package demoserver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// requestsTotal counts the demo requests by status code, with the trace
+	// ID of the request as exemplar when it carries one
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_http_requests_total",
+		Help: "Number of requests served by the demo server by status code.",
+	}, []string{"code"})
+	http200Requests = requestsTotal.WithLabelValues("200")
+	http500Requests = requestsTotal.WithLabelValues("500")
+	demoRegistry    = prometheus.NewRegistry()
+	metricsHandler  = promhttp.HandlerFor(demoRegistry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	// requests refused by the demo server rate limiter
+	rateLimitedRequestCounter = 0
+	rateLimitedmutex          = &sync.Mutex{}
+)
+
+func init() {
+	demoRegistry.MustRegister(requestsTotal)
+	// the demo counters by status code on /debug/vars, rate limited as 429
+	expvar.Publish("demo_requests", expvar.Func(func() interface{} {
+		stats := demoStats()
+		return map[string]int{
+			"200": stats.Http200Requestcounter,
+			"500": stats.Http500Requestcounter,
+			"429": stats.HttpRateLimitedcounter,
+		}
+	}))
+}
+
+// Http Message json structure
+type HttpRespStructure struct {
+	Http200Requestcounter  int `json:"http200Requestcounter"`
+	Http500Requestcounter  int `json:"http500Requestcounter"`
+	HttpRateLimitedcounter int `json:"httpRateLimitedcounter"`
+}
+
+// twoHundred
+func twoHundred(w http.ResponseWriter, r *http.Request) {
+	countRequest(http200Requests, r)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "HTTP Endpoint OK!"}`))
+}
+
+// fiveHundred
+func fiveHundred(w http.ResponseWriter, r *http.Request) {
+	countRequest(http500Requests, r)
+	// simulate 500 eror code
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(`{"message": "HTTP Endpoint Internal Error"}`))
+}
+
+// countRequest increments c, attaching the trace ID of a W3C traceparent
+// header as exemplar
+func countRequest(c prometheus.Counter, r *http.Request) {
+	if id := traceID(r.Header.Get("traceparent")); id != "" {
+		c.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"trace_id": id})
+		return
+	}
+	c.Inc()
+}
+
+// traceID returns the trace ID of a traceparent header of the form
+// version-traceid-parentid-flags, empty when it is missing or invalid
+func traceID(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+	return strings.ToLower(parts[1])
+}
+
+// counterValue reads the current value of c
+func counterValue(c prometheus.Counter) int {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		return 0
+	}
+	return int(m.GetCounter().GetValue())
+}
+
+// rateLimited answers 429 once the limiter runs out of tokens
+func rateLimited(limiter *rate.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			rateLimitedmutex.Lock()
+			rateLimitedRequestCounter++
+			rateLimitedmutex.Unlock()
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "HTTP Endpoint Rate Limited"}`))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// stats
+func stats(w http.ResponseWriter, r *http.Request) {
+	log.Infof("HttpServer statistics")
+	resp := demoStats()
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Failed encoding stats response: %v", err)
+	}
+}
+
+// demoStats takes a snapshot of the demo server's counters
+func demoStats() HttpRespStructure {
+	resp := HttpRespStructure{
+		Http200Requestcounter: counterValue(http200Requests),
+		Http500Requestcounter: counterValue(http500Requests),
+	}
+	rateLimitedmutex.Lock()
+	resp.HttpRateLimitedcounter = rateLimitedRequestCounter
+	rateLimitedmutex.Unlock()
+	return resp
+}
+
+// demoMetrics serves the demo counters in the Prometheus text or
+// OpenMetrics format, with exemplars only in the latter, to clients accepting
+// them and as stats JSON to all others
+func demoMetrics(w http.ResponseWriter, r *http.Request) {
+	if !acceptsText(r.Header.Get("Accept")) {
+		stats(w, r)
+		return
+	}
+	metricsHandler.ServeHTTP(w, r)
+}
+
+// acceptsText reports whether an Accept header asks for the Prometheus text
+// or OpenMetrics format
+func acceptsText(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/openmetrics-text" {
+			return true
+		}
+		if err != nil || mediaType != "text/plain" {
+			continue
+		}
+		if version, ok := params["version"]; !ok || version == "0.0.4" {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves the demo endpoints and the demo's own /metrics, limited to
+// rateLimit requests per second when it is positive, and /debug/vars with
+// enableExpvar
+func Handler(rateLimit float64, enableExpvar bool) http.Handler {
+	m := http.NewServeMux()
+	Register(m, rateLimit, enableExpvar)
+	m.HandleFunc("/metrics", demoMetrics)
+	return m
+}
+
+// Register adds /test200, /test500 and /stats to m, and /debug/vars with
+// the demo counters and memstats with enableExpvar, leaving /metrics to the
+// caller
+func Register(m *http.ServeMux, rateLimit float64, enableExpvar bool) {
+	if rateLimit > 0 {
+		limiter := rate.NewLimiter(rate.Limit(rateLimit), int(math.Max(1, rateLimit)))
+		m.HandleFunc("/test200", rateLimited(limiter, twoHundred))
+		m.HandleFunc("/test500", rateLimited(limiter, fiveHundred))
+	} else {
+		m.HandleFunc("/test200", twoHundred)
+		m.HandleFunc("/test500", fiveHundred)
+	}
+	m.HandleFunc("/stats", stats)
+	if enableExpvar {
+		m.Handle("/debug/vars", expvar.Handler())
+	}
+}
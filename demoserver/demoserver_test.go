@@ -0,0 +1,196 @@
+package demoserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Fathi122/simple-prometheus-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// get requests path from server and fails the test on errors
+func get(t *testing.T, server *httptest.Server, path string) *http.Response {
+	t.Helper()
+	response, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	return response
+}
+
+// gatherCounters returns the values of the request counters exported by c
+func gatherCounters(t *testing.T, c prometheus.Collector) map[string]int {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters := map[string]int{}
+	for _, family := range families {
+		if counter := family.GetMetric()[0].GetCounter(); counter != nil {
+			counters[family.GetName()] = int(counter.GetValue())
+		}
+	}
+	return counters
+}
+
+func TestStatsRoundTrip(t *testing.T) {
+	server := httptest.NewServer(Handler(0, false))
+	defer server.Close()
+	get(t, server, "/test200")
+	get(t, server, "/test200")
+	get(t, server, "/test500")
+	if response := get(t, server, "/stats"); response.StatusCode != http.StatusOK {
+		t.Fatalf("GET /stats: status %s", response.Status)
+	}
+
+	c, err := collector.NewCollector(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters := gatherCounters(t, c)
+	want := demoStats()
+	if counters["http_request_200counter"] != want.Http200Requestcounter || counters["http_request_500counter"] != want.Http500Requestcounter {
+		t.Errorf("exported counters %v, want the served stats %+v", counters, want)
+	}
+	if want.Http200Requestcounter < 2 || want.Http500Requestcounter < 1 {
+		t.Errorf("stats %+v miss the requests served", want)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		rateLimit float64
+		limited   bool
+	}{
+		{"limited", 1, true},
+		{"unlimited", 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(Handler(tc.rateLimit, false))
+			defer server.Close()
+			before := demoStats().HttpRateLimitedcounter
+			var tooMany int
+			for i := 0; i < 5; i++ {
+				if get(t, server, "/test200").StatusCode == http.StatusTooManyRequests {
+					tooMany++
+				}
+			}
+			if limited := tooMany > 0; limited != tc.limited {
+				t.Fatalf("%d of 5 requests answered 429 with a rate limit of %v", tooMany, tc.rateLimit)
+			}
+			if counted := demoStats().HttpRateLimitedcounter - before; counted != tooMany {
+				t.Errorf("stats count %d rate limited requests, want %d", counted, tooMany)
+			}
+		})
+	}
+}
+
+func TestMetricsContentNegotiation(t *testing.T) {
+	server := httptest.NewServer(Handler(0, false))
+	defer server.Close()
+	get(t, server, "/test200")
+	for _, tc := range []struct {
+		name        string
+		accept      string
+		contentType string
+		contains    string
+	}{
+		{"text", "text/plain; version=0.0.4", "text/plain; version=0.0.4", `demo_http_requests_total{code="200"}`},
+		// as sent by Prometheus
+		{"openmetrics", "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5", "application/openmetrics-text", `demo_http_requests_total{code="200"}`},
+		{"json", "application/json", "application/json", `"http200Requestcounter"`},
+		{"none", "", "application/json", `"http200Requestcounter"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, tc.contentType) {
+				t.Errorf("Content-Type %q, want %q", contentType, tc.contentType)
+			}
+			if !strings.Contains(string(body), tc.contains) {
+				t.Errorf("body misses %s:\n%s", tc.contains, body)
+			}
+		})
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	server := httptest.NewServer(Handler(0, false))
+	defer server.Close()
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, traceparent := range []string{"00-" + traceID + "-00f067aa0ba902b7-01", ""} {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/test500", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if traceparent != "" {
+			request.Header.Set("traceparent", traceparent)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the request without trace keeps the exemplar of the traced one
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, `demo_http_requests_total{code="500"} `) && strings.Contains(line, ` # {trace_id="`+traceID+`"} 1`) {
+			return
+		}
+	}
+	t.Errorf("no exemplar with trace ID %s:\n%s", traceID, body)
+}
+
+func TestTraceID(t *testing.T) {
+	for _, tc := range []struct {
+		traceparent string
+		want        string
+	}{
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"", ""},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", ""},
+	} {
+		if got := traceID(tc.traceparent); got != tc.want {
+			t.Errorf("traceID(%q) = %q, want %q", tc.traceparent, got, tc.want)
+		}
+	}
+}
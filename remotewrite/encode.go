@@ -0,0 +1,122 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// label and sample of a remote-write time series
+type label struct {
+	name, value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// toSeries flattens metric families into one series per sample, the way
+// Prometheus stores them, stamping samples without timestamp with nowMs
+func toSeries(families []*dto.MetricFamily, nowMs int64) []series {
+	var out []series
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := nowMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...label) {
+				labels := make([]label, 0, len(m.GetLabel())+len(extra)+1)
+				labels = append(labels, label{"__name__", name + suffix})
+				for _, l := range m.GetLabel() {
+					labels = append(labels, label{l.GetName(), l.GetValue()})
+				}
+				labels = append(labels, extra...)
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				out = append(out, series{labels: labels, value: value, timestamp: ts})
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add("_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			default:
+				add("", m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return out
+}
+
+// formatFloat formats bucket bounds and quantiles like the text format
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// field numbers of the remote-write WriteRequest protobuf
+const (
+	writeRequestTimeseries = 1
+	timeseriesLabels       = 1
+	timeseriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// marshalWriteRequest encodes series as a prometheus.WriteRequest
+func marshalWriteRequest(all []series) []byte {
+	var buf, ts, msg []byte
+	for _, s := range all {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, labelName, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, labelValue, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+			ts = protowire.AppendTag(ts, timeseriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, sampleValue, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, sampleTimestamp, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, timeseriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+		buf = protowire.AppendTag(buf, writeRequestTimeseries, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
@@ -0,0 +1,202 @@
+package remotewrite
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// labelPairs builds the labels of a metric from name, value pairs
+func labelPairs(pairs ...string) []*dto.LabelPair {
+	var out []*dto.LabelPair
+	for i := 0; i < len(pairs); i += 2 {
+		out = append(out, &dto.LabelPair{Name: proto.String(pairs[i]), Value: proto.String(pairs[i+1])})
+	}
+	return out
+}
+
+func TestToSeries(t *testing.T) {
+	const now = 1700000000000
+	for _, tc := range []struct {
+		name   string
+		family *dto.MetricFamily
+		want   []series
+	}{
+		{
+			name: "counter",
+			family: &dto.MetricFamily{Name: proto.String("requests_total"), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{
+				{Label: labelPairs("path", "/", "code", "200"), Counter: &dto.Counter{Value: proto.Float64(42)}},
+			}},
+			want: []series{
+				{labels: []label{{"__name__", "requests_total"}, {"code", "200"}, {"path", "/"}}, value: 42, timestamp: now},
+			},
+		},
+		{
+			name: "gauge_with_timestamp",
+			family: &dto.MetricFamily{Name: proto.String("temperature"), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{
+				{Gauge: &dto.Gauge{Value: proto.Float64(-1.5)}, TimestampMs: proto.Int64(1234)},
+			}},
+			want: []series{
+				{labels: []label{{"__name__", "temperature"}}, value: -1.5, timestamp: 1234},
+			},
+		},
+		{
+			name: "untyped",
+			family: &dto.MetricFamily{Name: proto.String("up"), Type: dto.MetricType_UNTYPED.Enum(), Metric: []*dto.Metric{
+				{Untyped: &dto.Untyped{Value: proto.Float64(1)}},
+			}},
+			want: []series{
+				{labels: []label{{"__name__", "up"}}, value: 1, timestamp: now},
+			},
+		},
+		{
+			name: "summary",
+			family: &dto.MetricFamily{Name: proto.String("size_bytes"), Type: dto.MetricType_SUMMARY.Enum(), Metric: []*dto.Metric{
+				{Summary: &dto.Summary{
+					SampleCount: proto.Uint64(4),
+					SampleSum:   proto.Float64(400),
+					Quantile:    []*dto.Quantile{{Quantile: proto.Float64(0.5), Value: proto.Float64(50)}, {Quantile: proto.Float64(0.99), Value: proto.Float64(300)}},
+				}},
+			}},
+			want: []series{
+				{labels: []label{{"__name__", "size_bytes"}, {"quantile", "0.5"}}, value: 50, timestamp: now},
+				{labels: []label{{"__name__", "size_bytes"}, {"quantile", "0.99"}}, value: 300, timestamp: now},
+				{labels: []label{{"__name__", "size_bytes_sum"}}, value: 400, timestamp: now},
+				{labels: []label{{"__name__", "size_bytes_count"}}, value: 4, timestamp: now},
+			},
+		},
+		{
+			name: "histogram_without_inf_bucket",
+			family: &dto.MetricFamily{Name: proto.String("duration_seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{
+				{Label: labelPairs("zone", "eu"), Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(3),
+					SampleSum:   proto.Float64(3.5),
+					Bucket:      []*dto.Bucket{{UpperBound: proto.Float64(0.1), CumulativeCount: proto.Uint64(1)}, {UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(2)}},
+				}},
+			}},
+			want: []series{
+				{labels: []label{{"__name__", "duration_seconds_bucket"}, {"le", "0.1"}, {"zone", "eu"}}, value: 1, timestamp: now},
+				{labels: []label{{"__name__", "duration_seconds_bucket"}, {"le", "1"}, {"zone", "eu"}}, value: 2, timestamp: now},
+				{labels: []label{{"__name__", "duration_seconds_bucket"}, {"le", "+Inf"}, {"zone", "eu"}}, value: 3, timestamp: now},
+				{labels: []label{{"__name__", "duration_seconds_sum"}, {"zone", "eu"}}, value: 3.5, timestamp: now},
+				{labels: []label{{"__name__", "duration_seconds_count"}, {"zone", "eu"}}, value: 3, timestamp: now},
+			},
+		},
+		{
+			name: "histogram_with_inf_bucket",
+			family: &dto.MetricFamily{Name: proto.String("duration_seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{
+				{Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(2),
+					SampleSum:   proto.Float64(5),
+					Bucket:      []*dto.Bucket{{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(1)}, {UpperBound: proto.Float64(math.Inf(1)), CumulativeCount: proto.Uint64(2)}},
+				}},
+			}},
+			want: []series{
+				{labels: []label{{"__name__", "duration_seconds_bucket"}, {"le", "1"}}, value: 1, timestamp: now},
+				{labels: []label{{"__name__", "duration_seconds_bucket"}, {"le", "+Inf"}}, value: 2, timestamp: now},
+				{labels: []label{{"__name__", "duration_seconds_sum"}}, value: 5, timestamp: now},
+				{labels: []label{{"__name__", "duration_seconds_count"}}, value: 2, timestamp: now},
+			},
+		},
+		{
+			name:   "no_metrics",
+			family: &dto.MetricFamily{Name: proto.String("empty"), Type: dto.MetricType_GAUGE.Enum()},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := toSeries([]*dto.MetricFamily{tc.family}, now); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("toSeries =\n%v\nwant\n%v", got, tc.want)
+			}
+		})
+	}
+}
+
+// unmarshalWriteRequest decodes a WriteRequest field by field, failing on
+// anything marshalWriteRequest does not write
+func unmarshalWriteRequest(t *testing.T, b []byte) []series {
+	t.Helper()
+	// next consumes a length-delimited field of number num from b
+	next := func(b []byte, num protowire.Number) ([]byte, []byte) {
+		gotNum, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || gotNum != num || typ != protowire.BytesType {
+			t.Fatalf("field %d of type %d, want %d of bytes", gotNum, typ, num)
+		}
+		value, m := protowire.ConsumeBytes(b[n:])
+		if m < 0 {
+			t.Fatalf("truncated field %d", num)
+		}
+		return value, b[n+m:]
+	}
+	var out []series
+	for len(b) > 0 {
+		var ts []byte
+		ts, b = next(b, writeRequestTimeseries)
+		var s series
+		for len(ts) > 0 {
+			var msg, name, value []byte
+			if num, _, _ := protowire.ConsumeTag(ts); num == timeseriesLabels {
+				msg, ts = next(ts, timeseriesLabels)
+				name, msg = next(msg, labelName)
+				value, msg = next(msg, labelValue)
+				if len(msg) > 0 {
+					t.Fatalf("trailing bytes in label %s", name)
+				}
+				s.labels = append(s.labels, label{string(name), string(value)})
+				continue
+			}
+			msg, ts = next(ts, timeseriesSamples)
+			if num, typ, n := protowire.ConsumeTag(msg); num != sampleValue || typ != protowire.Fixed64Type {
+				t.Fatalf("sample field %d of type %d, want value", num, typ)
+			} else {
+				msg = msg[n:]
+			}
+			bits, n := protowire.ConsumeFixed64(msg)
+			s.value, msg = math.Float64frombits(bits), msg[n:]
+			if num, typ, n := protowire.ConsumeTag(msg); num != sampleTimestamp || typ != protowire.VarintType {
+				t.Fatalf("sample field %d of type %d, want timestamp", num, typ)
+			} else {
+				msg = msg[n:]
+			}
+			timestamp, n := protowire.ConsumeVarint(msg)
+			if n < 0 || len(msg) != n {
+				t.Fatal("malformed sample timestamp")
+			}
+			s.timestamp = int64(timestamp)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestMarshalWriteRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		series []series
+	}{
+		{"empty", nil},
+		{"one", []series{
+			{labels: []label{{"__name__", "up"}}, value: 1, timestamp: 1700000000000},
+		}},
+		{"several", []series{
+			{labels: []label{{"__name__", "requests_total"}, {"code", "200"}, {"path", "/ä"}}, value: 42, timestamp: 1},
+			{labels: []label{{"__name__", "temperature"}, {"sensor", ""}}, value: -12.5, timestamp: 2},
+			{labels: []label{{"__name__", "inf"}}, value: math.Inf(1), timestamp: 3},
+			{labels: []label{{"__name__", "negative_timestamp"}}, value: 0, timestamp: -1},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := unmarshalWriteRequest(t, marshalWriteRequest(tc.series)); !reflect.DeepEqual(got, tc.series) {
+				t.Errorf("round trip =\n%v\nwant\n%v", got, tc.series)
+			}
+		})
+	}
+	// NaN never equals itself, compare its bits
+	got := unmarshalWriteRequest(t, marshalWriteRequest([]series{{labels: []label{{"__name__", "nan"}}, value: math.NaN()}}))
+	if len(got) != 1 || math.Float64bits(got[0].value) != math.Float64bits(math.NaN()) {
+		t.Errorf("NaN round trip = %v", got)
+	}
+}
@@ -0,0 +1,391 @@
+// Package remotewrite ships the metrics of a gatherer to a Prometheus
+// remote-write endpoint
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaults of the sender
+const (
+	DefaultInterval  = 30 * time.Second
+	DefaultQueueSize = 10
+	// maxBackoff bounds the wait between retries of a batch
+	maxBackoff = 30 * time.Second
+	minBackoff = 500 * time.Millisecond
+)
+
+// Logger receives the sender's log messages, *logrus.Logger satisfies it
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+type options struct {
+	client                *http.Client
+	interval              time.Duration
+	queueSize             int
+	bearerTokenFile       string
+	basicAuthUser         string
+	basicAuthPasswordFile string
+	logger                Logger
+}
+
+// Option configures a Sender
+type Option func(*options) error
+
+// WithHTTPClient sends with client instead of a client of its own
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) error {
+		if client == nil {
+			return errors.New("nil HTTP client")
+		}
+		o.client = client
+		return nil
+	}
+}
+
+// WithTLSFiles verifies the endpoint with the CA in caFile, presenting the
+// client certificate of certFile and keyFile when given. It replaces the
+// transport of the client.
+func WithTLSFiles(caFile, certFile, keyFile string, insecureSkipVerify bool) Option {
+	return func(o *options) error {
+		if (certFile == "") != (keyFile == "") {
+			return errors.New("remote write client certificate and key files must be given together")
+		}
+		config := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return fmt.Errorf("failed reading remote write CA file: %w", err)
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no PEM certificates found in remote write CA file %s", caFile)
+			}
+		}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return fmt.Errorf("failed loading remote write client certificate: %w", err)
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		client := *o.client
+		client.Transport = transport
+		o.client = &client
+		return nil
+	}
+}
+
+// WithInterval gathers and sends every interval
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return fmt.Errorf("remote write interval must be positive, got %s", interval)
+		}
+		o.interval = interval
+		return nil
+	}
+}
+
+// WithQueueSize keeps at most size batches waiting to be sent, dropping
+// new ones once it is full
+func WithQueueSize(size int) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return fmt.Errorf("remote write queue size must be positive, got %d", size)
+		}
+		o.queueSize = size
+		return nil
+	}
+}
+
+// WithBearerTokenFile sends the token read from file on every request
+func WithBearerTokenFile(file string) Option {
+	return func(o *options) error {
+		o.bearerTokenFile = file
+		return nil
+	}
+}
+
+// WithBasicAuth authenticates as user with the password read from passwordFile on every request
+func WithBasicAuth(user, passwordFile string) Option {
+	return func(o *options) error {
+		o.basicAuthUser, o.basicAuthPasswordFile = user, passwordFile
+		return nil
+	}
+}
+
+// WithLogger
+func WithLogger(logger Logger) Option {
+	return func(o *options) error {
+		if logger == nil {
+			return errors.New("nil logger")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// batch is an encoded write request
+type batch struct {
+	body    []byte
+	samples int
+}
+
+// Sender gathers metrics on an interval and sends them to a remote-write
+// endpoint from a queue of its own, so slow or failing sends never hold up
+// scrapes of the gatherer. It is a prometheus.Collector of its queue metrics.
+type Sender struct {
+	url      string
+	gatherer prometheus.Gatherer
+	options
+	queue chan batch
+
+	queueDepth     prometheus.GaugeFunc
+	samplesSent    prometheus.Counter
+	samplesDropped prometheus.Counter
+	failures       prometheus.Counter
+}
+
+// NewSender creates a sender of the metrics of gatherer to url
+func NewSender(endpoint string, gatherer prometheus.Gatherer, opts ...Option) (*Sender, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote write url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("remote write url %s is not http or https", u.Redacted())
+	}
+	o := options{
+		client:    &http.Client{Timeout: maxBackoff},
+		interval:  DefaultInterval,
+		queueSize: DefaultQueueSize,
+		logger:    nopLogger{},
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if o.bearerTokenFile != "" && o.basicAuthUser != "" {
+		return nil, errors.New("bearer token and basic auth are mutually exclusive")
+	}
+	s := &Sender{
+		url:      endpoint,
+		gatherer: gatherer,
+		options:  o,
+		queue:    make(chan batch, o.queueSize),
+		samplesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_remote_write_samples_sent_total",
+			Help: "Number of samples accepted by the remote-write endpoint.",
+		}),
+		samplesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_remote_write_samples_dropped_total",
+			Help: "Number of samples dropped on a full queue or rejected by the remote-write endpoint.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_remote_write_failures_total",
+			Help: "Number of failed requests to the remote-write endpoint, retries included.",
+		}),
+	}
+	s.queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "exporter_remote_write_queue_depth",
+		Help: "Number of batches waiting to be sent to the remote-write endpoint.",
+	}, func() float64 { return float64(len(s.queue)) })
+	return s, nil
+}
+
+// Describe
+func (s *Sender) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range s.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect
+func (s *Sender) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range s.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// collectors
+func (s *Sender) collectors() []prometheus.Collector {
+	return []prometheus.Collector{s.queueDepth, s.samplesSent, s.samplesDropped, s.failures}
+}
+
+// Run gathers every interval and sends the batches until ctx is done
+func (s *Sender) Run(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.send(ctx)
+	}()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.enqueue()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			<-done
+			return
+		}
+	}
+}
+
+// enqueue gathers and queues a batch, dropping it when the queue is full
+func (s *Sender) enqueue() {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could gather along with the error
+		s.logger.Warnf("Failed gathering some metrics for remote write: %v", err)
+	}
+	all := toSeries(families, time.Now().UnixNano()/int64(time.Millisecond))
+	if len(all) == 0 {
+		return
+	}
+	b := batch{body: snappy.Encode(nil, marshalWriteRequest(all)), samples: len(all)}
+	select {
+	case s.queue <- b:
+	default:
+		s.samplesDropped.Add(float64(b.samples))
+		s.logger.Warnf("Remote write queue is full, dropping %d samples", b.samples)
+	}
+}
+
+// send posts the queued batches in order until ctx is done
+func (s *Sender) send(ctx context.Context) {
+	for {
+		select {
+		case b := <-s.queue:
+			s.sendWithRetry(ctx, b)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendWithRetry retries a batch on 429, 5xx and connection errors with
+// exponential backoff, honoring Retry-After, and drops it on other errors
+func (s *Sender) sendWithRetry(ctx context.Context, b batch) {
+	backoff := minBackoff
+	for {
+		retryAfter, err := s.post(ctx, b.body)
+		if err == nil {
+			s.samplesSent.Add(float64(b.samples))
+			return
+		}
+		s.failures.Inc()
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			s.samplesDropped.Add(float64(b.samples))
+			s.logger.Errorf("Remote write rejected %d samples: %v", b.samples, err)
+			return
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		s.logger.Warnf("Failed remote write, retrying in %s: %v", wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// permanentError is a response that retrying does not change
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// post sends one batch, returning the Retry-After of a 429 response
+func (s *Sender) post(ctx context.Context, body []byte) (time.Duration, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, &permanentError{err}
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if err := s.setAuthorization(request); err != nil {
+		return 0, err
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		io.Copy(io.Discard, response.Body)
+		return 0, nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	err = fmt.Errorf("server returned HTTP status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(response.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, err
+	case response.StatusCode/100 == 5:
+		return 0, err
+	}
+	return 0, &permanentError{err}
+}
+
+// setAuthorization adds the configured credentials, read from their files
+func (s *Sender) setAuthorization(request *http.Request) error {
+	switch {
+	case s.bearerTokenFile != "":
+		token, err := os.ReadFile(s.bearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed reading bearer token file: %w", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	case s.basicAuthUser != "":
+		var password []byte
+		if s.basicAuthPasswordFile != "" {
+			var err error
+			password, err = os.ReadFile(s.basicAuthPasswordFile)
+			if err != nil {
+				return fmt.Errorf("failed reading basic auth password file: %w", err)
+			}
+		}
+		request.SetBasicAuth(s.basicAuthUser, strings.TrimSpace(string(password)))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSenderWireFormat(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total", Help: "Requests."})
+	requests.Add(7)
+	registry.MustRegister(requests)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []series, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for header, want := range map[string]string{
+			"Content-Encoding":                  "snappy",
+			"Content-Type":                      "application/x-protobuf",
+			"X-Prometheus-Remote-Write-Version": "0.1.0",
+			"Authorization":                     "Bearer s3cret",
+		} {
+			if got := r.Header.Get(header); got != want {
+				t.Errorf("%s = %q, want %q", header, got, want)
+			}
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("body is not snappy: %v", err)
+		}
+		received <- unmarshalWriteRequest(t, body)
+	}))
+	defer server.Close()
+	s, err := NewSender(server.URL, registry, WithBearerTokenFile(tokenFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	s.enqueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.sendWithRetry(ctx, <-s.queue)
+	got := <-received
+	if len(got) != 1 || got[0].timestamp < before {
+		t.Fatalf("received %v, want one sample stamped after %d", got, before)
+	}
+	if want := []label{{"__name__", "requests_total"}}; !reflect.DeepEqual(got[0].labels, want) || got[0].value != 7 {
+		t.Errorf("received %v, want requests_total 7", got[0])
+	}
+	if sent := testutil.ToFloat64(s.samplesSent); sent != 1 {
+		t.Errorf("exporter_remote_write_samples_sent_total = %v, want 1", sent)
+	}
+}
+
+func TestSenderPostStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantErr    bool
+		permanent  bool
+		wait       time.Duration
+	}{
+		{name: "ok", status: http.StatusNoContent},
+		{name: "too_many_requests", status: http.StatusTooManyRequests, retryAfter: "3", wantErr: true, wait: 3 * time.Second},
+		{name: "too_many_requests_without_retry_after", status: http.StatusTooManyRequests, wantErr: true},
+		{name: "server_error", status: http.StatusServiceUnavailable, wantErr: true},
+		{name: "bad_request", status: http.StatusBadRequest, wantErr: true, permanent: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+			s, err := NewSender(server.URL, prometheus.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+			wait, err := s.post(context.Background(), nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("post error = %v, want error %v", err, tc.wantErr)
+			}
+			var permanent *permanentError
+			if errors.As(err, &permanent) != tc.permanent {
+				t.Errorf("post error %v permanent = %v, want %v", err, !tc.permanent, tc.permanent)
+			}
+			if wait != tc.wait {
+				t.Errorf("retry after %s, want %s", wait, tc.wait)
+			}
+		})
+	}
+}